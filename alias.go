@@ -0,0 +1,161 @@
+package findnil
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+
+	"github.com/gostaticanalysis/findnil/internal/pointer"
+	"golang.org/x/tools/go/ssa"
+)
+
+// addAliasQueries adds queries of the addresses which values which may be nil are
+// loaded from or stored to in the source functions of the initial packages
+// and the main package of the library mode.
+// Their points-to sets tell which stores through other pointers may set the values of loads.
+// Packages which are only imported, such as the standard library, are not queried
+// because their findings are not reported.
+func (prog *Program) addAliasQueries(config *pointer.Config) {
+	for _, pkg := range prog.aliasPackages() {
+		funcs := prog.SrcFuncs[pkg]
+		// package level variables are initialized in the init function
+		if init := pkg.Func("init"); init != nil {
+			funcs = append([]*ssa.Function{init}, funcs...)
+		}
+		if pkg.Pkg.Path() == libraryMainPath {
+			funcs = append(funcs, pkg.Func("main"))
+		}
+		for _, fn := range funcs {
+			for _, b := range fn.Blocks {
+				for _, instr := range b.Instrs {
+					var addr ssa.Value
+					switch instr := instr.(type) {
+					case *ssa.Store:
+						if !canBeNil(instr.Val.Type()) {
+							continue
+						}
+						addr = instr.Addr
+						prog.stores = append(prog.stores, instr)
+					case *ssa.UnOp:
+						if instr.Op != token.MUL || !canBeNil(instr.Type()) {
+							continue
+						}
+						addr = instr.X
+					default:
+						continue
+					}
+					if pointer.CanPoint(addr.Type()) {
+						config.AddQuery(addr)
+					}
+				}
+			}
+		}
+	}
+}
+
+// aliasPackages returns the initial packages and the main package of the library mode.
+func (prog *Program) aliasPackages() []*ssa.Package {
+	pkgs := make([]*ssa.Package, 0, len(prog.Initial)+1)
+	pkgs = append(pkgs, prog.Initial...)
+	for _, main := range prog.Mains {
+		if main.Pkg.Path() == libraryMainPath {
+			pkgs = append(pkgs, main)
+		}
+	}
+	return pkgs
+}
+
+// labelKey identifies a label of a points-to set.
+// Labels are compared by their allocations and paths
+// because each call of Labels returns new labels.
+type labelKey struct {
+	value ssa.Value
+	path  string
+}
+
+// isNilAliased reports whether a nil value may be stored to the variable which load loads from
+// through a pointer which is not the address of load.
+// The pointers are found by the points-to sets of the pointer analysis,
+// which over-approximate the variables they point to.
+func isNilAliased(prog *Program, memo *nilMemo, load *ssa.UnOp) *nilFlow {
+	ptr, ok := prog.PointsTo[load.X]
+	if !ok {
+		return nil
+	}
+	pts := ptr.PointsTo()
+
+	// stores are checked in the order of the program
+	var indexes []int
+	seen := make(map[int]bool)
+	byLabel := prog.storesByLabel()
+	for _, l := range pts.Labels() {
+		for _, i := range byLabel[labelKey{l.Value(), l.Path()}] {
+			if !seen[i] {
+				seen[i] = true
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		store := prog.stores[i]
+		if store.Addr == load.X {
+			continue
+		}
+
+		flow := isNil(prog, memo, store.Val)
+		if flow == nil {
+			continue
+		}
+
+		msg := "stored through a pointer"
+		if label := sharedLabel(pts, prog.PointsTo[store.Addr].PointsTo()); label != nil {
+			msg = fmt.Sprintf("stored through a pointer which may point to %s", label)
+		}
+		return &nilFlow{
+			prev:  flow,
+			pos:   store.Pos(),
+			msg:   msg,
+			conf:  ConfidenceLow,
+			value: store,
+			note:  "aliased by the pointer analysis",
+		}
+	}
+
+	return nil
+}
+
+// storesByLabel returns the indexes of prog.stores by the labels which their addresses may point to.
+func (prog *Program) storesByLabel() map[labelKey][]int {
+	if prog.aliasStores != nil {
+		return prog.aliasStores
+	}
+
+	prog.aliasStores = make(map[labelKey][]int)
+	for i, store := range prog.stores {
+		ptr, ok := prog.PointsTo[store.Addr]
+		if !ok {
+			continue
+		}
+		for _, l := range ptr.PointsTo().Labels() {
+			key := labelKey{l.Value(), l.Path()}
+			prog.aliasStores[key] = append(prog.aliasStores[key], i)
+		}
+	}
+	return prog.aliasStores
+}
+
+// sharedLabel returns a label which both x and y contain.
+func sharedLabel(x, y pointer.PointsToSet) *pointer.Label {
+	labels := make(map[labelKey]bool)
+	for _, l := range y.Labels() {
+		labels[labelKey{l.Value(), l.Path()}] = true
+	}
+	for _, l := range x.Labels() {
+		if labels[labelKey{l.Value(), l.Path()}] {
+			return l
+		}
+	}
+	return nil
+}
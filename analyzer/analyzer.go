@@ -0,0 +1,279 @@
+// Package analyzer provides findnil as an analysis.Analyzer.
+//
+// Unlike findnil.Cmd, which analyzes a whole program with the pointer analysis,
+// the analyzer works on a package at a time.
+// Which results of a function may be nil is carried across package boundaries as a NilResults fact.
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/buildssa"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/ssa"
+)
+
+const doc = "findnil finds dereferences of values which may be nil"
+
+// Analyzer finds dereferences of values which may be nil.
+var Analyzer = &analysis.Analyzer{
+	Name: "findnil",
+	Doc:  doc,
+	Run:  run,
+	Requires: []*analysis.Analyzer{
+		buildssa.Analyzer,
+		inspect.Analyzer,
+	},
+	FactTypes: []analysis.Fact{new(NilResults)},
+}
+
+// NilResults is a fact that a function may return nil.
+type NilResults struct {
+	// Indexes are the indexes of the results which may be nil.
+	Indexes []int
+}
+
+// AFact implements analysis.Fact.
+func (*NilResults) AFact() {}
+
+func (f *NilResults) String() string {
+	return fmt.Sprintf("nilResults%v", f.Indexes)
+}
+
+func (f *NilResults) has(i int) bool {
+	for _, idx := range f.Indexes {
+		if idx == i {
+			return true
+		}
+	}
+	return false
+}
+
+type checker struct {
+	pass    *analysis.Pass
+	funcs   []*ssa.Function
+	results map[*ssa.Function]*NilResults
+	// stores of package level variables in the package
+	stores map[*ssa.Global][]*ssa.Store
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	ssainfo := pass.ResultOf[buildssa.Analyzer].(*buildssa.SSA)
+	c := &checker{
+		pass:    pass,
+		funcs:   ssainfo.SrcFuncs,
+		results: make(map[*ssa.Function]*NilResults),
+		stores:  make(map[*ssa.Global][]*ssa.Store),
+	}
+
+	// package level variables are initialized in the init function
+	funcs := c.funcs
+	if init := ssainfo.Pkg.Func("init"); init != nil {
+		funcs = append([]*ssa.Function{init}, funcs...)
+	}
+	for _, fn := range funcs {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				store, _ := instr.(*ssa.Store)
+				if store == nil {
+					continue
+				}
+				if g, _ := store.Addr.(*ssa.Global); g != nil {
+					c.stores[g] = append(c.stores[g], store)
+				}
+			}
+		}
+	}
+
+	c.summarize()
+	c.exportFacts()
+	c.check(pass.ResultOf[inspect.Analyzer].(*inspector.Inspector))
+
+	return nil, nil
+}
+
+// summarize computes which results of each function in the package may be nil.
+// Functions in the package may call each other, so it iterates until no summary changes.
+func (c *checker) summarize() {
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range c.funcs {
+			results := fn.Signature.Results()
+			if results.Len() == 0 {
+				continue
+			}
+
+			var indexes []int
+			for i := 0; i < results.Len(); i++ {
+				for _, b := range fn.Blocks {
+					ret, _ := b.Instrs[len(b.Instrs)-1].(*ssa.Return)
+					if ret != nil && c.mayBeNil(ret.Results[i], make(map[ssa.Value]bool)) {
+						indexes = append(indexes, i)
+						break
+					}
+				}
+			}
+
+			if len(indexes) != len(c.summary(fn).Indexes) {
+				c.results[fn] = &NilResults{Indexes: indexes}
+				changed = true
+			}
+		}
+	}
+}
+
+func (c *checker) exportFacts() {
+	for _, fn := range c.funcs {
+		obj, _ := fn.Object().(*types.Func)
+		if obj == nil || obj.Pkg() != c.pass.Pkg {
+			continue
+		}
+		if fact := c.results[fn]; fact != nil && len(fact.Indexes) != 0 {
+			c.pass.ExportObjectFact(obj, fact)
+		}
+	}
+}
+
+// summary returns the summary of fn from the package or facts.
+func (c *checker) summary(fn *ssa.Function) *NilResults {
+	if fact := c.results[fn]; fact != nil {
+		return fact
+	}
+
+	obj, _ := fn.Object().(*types.Func)
+	var fact NilResults
+	if obj != nil && obj.Pkg() != c.pass.Pkg && c.pass.ImportObjectFact(obj, &fact) {
+		return &fact
+	}
+
+	return &NilResults{}
+}
+
+// mayBeNil reports whether v may be nil.
+func (c *checker) mayBeNil(v ssa.Value, done map[ssa.Value]bool) bool {
+	if done[v] {
+		return false
+	}
+	done[v] = true
+
+	switch v := v.(type) {
+	case *ssa.Const:
+		return v.IsNil()
+	case *ssa.Phi:
+		for _, e := range v.Edges {
+			if c.mayBeNil(e, done) {
+				return true
+			}
+		}
+	case *ssa.ChangeType:
+		return c.mayBeNil(v.X, done)
+	case *ssa.Call:
+		if callee := v.Call.StaticCallee(); callee != nil {
+			return c.summary(callee).has(0)
+		}
+	case *ssa.Extract:
+		if call, _ := v.Tuple.(*ssa.Call); call != nil {
+			if callee := call.Call.StaticCallee(); callee != nil {
+				return c.summary(callee).has(v.Index)
+			}
+		}
+	case *ssa.UnOp:
+		if g, _ := v.X.(*ssa.Global); v.Op == token.MUL && g != nil && g.Pkg.Pkg == c.pass.Pkg {
+			return c.mayBeNilGlobal(g, done)
+		}
+	}
+
+	return false
+}
+
+// mayBeNilGlobal reports whether the package level variable g may be nil.
+// A variable which is never assigned in the package keeps its zero value.
+func (c *checker) mayBeNilGlobal(g *ssa.Global, done map[ssa.Value]bool) bool {
+	stores := c.stores[g]
+	if len(stores) == 0 {
+		return canBeNil(g.Type().(*types.Pointer).Elem())
+	}
+
+	for _, store := range stores {
+		if c.mayBeNil(store.Val, done) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func canBeNil(typ types.Type) bool {
+	switch typ.Underlying().(type) {
+	case *types.Pointer, *types.Interface, *types.Map, *types.Slice,
+		*types.Chan, *types.Signature:
+		return true
+	}
+	return false
+}
+
+func (c *checker) check(inspect *inspector.Inspector) {
+	sels := make(map[token.Pos]*ast.SelectorExpr)
+	calls := make(map[token.Pos]*ast.SelectorExpr)
+	filter := []ast.Node{(*ast.SelectorExpr)(nil), (*ast.CallExpr)(nil)}
+	inspect.Preorder(filter, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			sels[n.Sel.Pos()] = n
+		case *ast.CallExpr:
+			if sel, _ := n.Fun.(*ast.SelectorExpr); sel != nil {
+				calls[n.Lparen] = sel
+			}
+		}
+	})
+
+	reported := make(map[*ast.SelectorExpr]bool)
+	var found []*ast.SelectorExpr
+	report := func(sel *ast.SelectorExpr, v ssa.Value, instr ssa.Instruction) {
+		if sel == nil || reported[sel] || nilcheck.IsGuarded(v, instr) {
+			return
+		}
+		if c.mayBeNil(v, make(map[ssa.Value]bool)) {
+			reported[sel] = true
+			found = append(found, sel)
+		}
+	}
+
+	for _, fn := range c.funcs {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				switch instr := instr.(type) {
+				case *ssa.FieldAddr:
+					report(sels[instr.Pos()], instr.X, instr)
+				case ssa.CallInstruction:
+					common := instr.Common()
+					if common.IsInvoke() {
+						report(calls[common.Pos()], common.Value, instr)
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Pos() < found[j].Pos()
+	})
+	for _, sel := range found {
+		c.pass.Reportf(sel.Pos(), "%s may be nil", c.exprString(sel))
+	}
+}
+
+func (c *checker) exprString(n ast.Node) string {
+	var buf bytes.Buffer
+	format.Node(&buf, c.pass.Fset, n)
+	return buf.String()
+}
@@ -0,0 +1,13 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"github.com/gostaticanalysis/findnil/analyzer"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, analyzer.Analyzer, "a", "b")
+}
@@ -0,0 +1,77 @@
+package a
+
+type T struct {
+	N int
+}
+
+var gt *T
+
+var initialized = &T{}
+
+func F() *T { // want F:`nilResults\[0\]`
+	return nil
+}
+
+func G() (*T, error) { // want G:`nilResults\[0 1\]`
+	return nil, nil
+}
+
+func h(n int) *T { // want h:`nilResults\[0\]`
+	if n%2 == 0 {
+		return gt
+	}
+	return new(T)
+}
+
+func f() {
+	println(gt.N) // want `gt.N may be nil`
+	println(initialized.N)
+
+	var t *T
+	println(t.N) // want `t.N may be nil`
+
+	t2 := h(2)
+	println(t2.N) // want `t2.N may be nil`
+
+	var err error
+	println(err.Error()) // want `err.Error may be nil`
+
+	t3 := F()
+	if t3 != nil {
+		println(t3.N)
+	}
+
+	t4, err := G()
+	if err != nil {
+		return
+	}
+	if t4 == nil {
+		return
+	}
+	println(t4.N)
+
+	println(new(T).N)
+
+	if gt != nil {
+		println(gt.N)
+	}
+
+	if gt != nil {
+		reset()
+		println(gt.N) // want `gt.N may be nil`
+	}
+}
+
+func reset() {
+	gt = nil
+}
+
+func guards() {
+	if gt == nil {
+		return
+	}
+	println(gt.N)
+
+	gt = nil
+	println(gt.N) // want `gt.N may be nil`
+}
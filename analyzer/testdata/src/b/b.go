@@ -0,0 +1,11 @@
+package b
+
+import "a"
+
+func f() {
+	t := a.F()
+	println(t.N) // want `t.N may be nil`
+
+	t2, _ := a.G()
+	println(t2.N) // want `t2.N may be nil`
+}
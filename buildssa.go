@@ -5,26 +5,52 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
+	"sort"
+	"strings"
 
+	"github.com/gostaticanalysis/findnil/internal/pointer"
 	"github.com/gostaticanalysis/findnil/nilless"
+	"golang.org/x/tools/go/callgraph"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
 )
 
 type Program struct {
-	Nilless   *nilless.Result
-	SSA       *ssa.Program
-	Packages  []*ssa.Package
+	Nilless  *nilless.Result
+	SSA      *ssa.Program
+	Packages []*ssa.Package
+	// Initial are the packages matched by the patterns.
+	Initial   []*ssa.Package
 	Mains     []*ssa.Package
 	SrcFuncs  map[*ssa.Package][]*ssa.Function
 	Fset      *token.FileSet
 	TypesInfo map[*ssa.Package]*types.Info
 	Files     map[*ssa.Package][]*ast.File
+	// CallGraph is the call graph built by the pointer analysis.
+	CallGraph *callgraph.Graph
+	// PointsTo holds the pointers of the addresses which values are loaded from or stored to.
+	PointsTo map[ssa.Value]pointer.Pointer
+	// Roots are the exported functions called by the synthetic main package in the library mode.
+	Roots       map[*ssa.Function]bool
+	ParamPolicy ParamPolicy
+
+	// reaching caches reaching definitions of local variables per function.
+	reaching map[*ssa.Function]*reaching
+	// fields caches stores to fields of structs.
+	fields map[*types.Var][]*ssa.Store
+	// nilSafe caches whether methods can be called with nil receivers.
+	nilSafe map[*ssa.Function]bool
+	// stores are the stores of values which may be nil, whose addresses are queried.
+	stores []*ssa.Store
+	// aliasStores indexes stores by the labels which their addresses may point to.
+	aliasStores map[labelKey][]int
 }
 
 func buildSSA(result *nilless.Result) (*Program, error) {
 
-	mode := ssa.GlobalDebug | ssa.NaiveForm | ssa.BareInits
+	// generic functions are built for each instantiation
+	mode := ssa.GlobalDebug | ssa.NaiveForm | ssa.BareInits | ssa.InstantiateGenerics
 	prog := &Program{
 		Nilless:   result,
 		SSA:       ssa.NewProgram(result.Fset, mode),
@@ -35,11 +61,18 @@ func buildSSA(result *nilless.Result) (*Program, error) {
 	}
 
 	// Create SSA packages for all imports.
-	// Order is not significant.
 	created := make(map[*packages.Package]bool)
 	var createAll func(pkgs map[string]*packages.Package)
 	createAll = func(pkgs map[string]*packages.Package) {
-		for _, p := range pkgs {
+		// imports are created in the order of their paths
+		// because the order of prog.Packages affects the order of the analysis
+		paths := make([]string, 0, len(pkgs))
+		for path := range pkgs {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			p := pkgs[path]
 			if !created[p] {
 				created[p] = true
 				ssapkg := prog.SSA.CreatePackage(p.Types, p.Syntax, p.TypesInfo, true)
@@ -55,15 +88,22 @@ func buildSSA(result *nilless.Result) (*Program, error) {
 	}
 
 	for _, pkg := range result.Pkgs {
-		created[pkg] = true
-		ssapkg := prog.SSA.CreatePackage(pkg.Types, pkg.Syntax, pkg.TypesInfo, true)
-		if pkg.Types.Name() == "main" {
-			prog.Mains = append(prog.Mains, ssapkg)
+		if !created[pkg] {
+			created[pkg] = true
+			ssapkg := prog.SSA.CreatePackage(pkg.Types, pkg.Syntax, pkg.TypesInfo, true)
+			if pkg.Types.Name() == "main" {
+				prog.Mains = append(prog.Mains, ssapkg)
+			}
+			prog.Files[ssapkg] = pkg.Syntax
+			prog.TypesInfo[ssapkg] = pkg.TypesInfo
+			prog.Packages = append(prog.Packages, ssapkg)
 		}
-		prog.Files[ssapkg] = pkg.Syntax
-		prog.TypesInfo[ssapkg] = pkg.TypesInfo
-		prog.Packages = append(prog.Packages, ssapkg)
 		createAll(pkg.Imports)
+
+		// generated test main packages are not analyzed
+		if !strings.HasSuffix(pkg.ID, ".test") {
+			prog.Initial = append(prog.Initial, prog.SSA.Package(pkg.Types))
+		}
 	}
 
 	prog.SSA.Build()
@@ -100,5 +140,82 @@ func buildSSA(result *nilless.Result) (*Program, error) {
 		}
 	}
 
+	prog.addInstances()
+
 	return prog, nil
 }
+
+// addInstances replaces the generic functions in SrcFuncs with their instantiations,
+// so that they are analyzed for each instantiation.
+func (prog *Program) addInstances() {
+	generics := make(map[token.Pos]*ssa.Package)
+	for pkg, funcs := range prog.SrcFuncs {
+		nonGenerics := funcs[:0]
+		for _, fn := range funcs {
+			switch {
+			case isGeneric(fn):
+				generics[fn.Pos()] = pkg
+			case !isGeneric(outermost(fn)):
+				nonGenerics = append(nonGenerics, fn)
+			}
+		}
+		prog.SrcFuncs[pkg] = nonGenerics
+	}
+	if len(generics) == 0 {
+		return
+	}
+
+	var instances []*ssa.Function
+	for fn := range ssautil.AllFunctions(prog.SSA) {
+		if isInstance(fn) && generics[fn.Pos()] != nil {
+			instances = append(instances, fn)
+		}
+	}
+	// positions are compared by offsets in files
+	// because the bases of files in the file set depend on the order of parsing
+	sort.Slice(instances, func(i, j int) bool {
+		pi, pj := prog.Fset.Position(instances[i].Pos()), prog.Fset.Position(instances[j].Pos())
+		if pi != pj {
+			return positionLess(pi, pj)
+		}
+		return instances[i].Name() < instances[j].Name()
+	})
+
+	var addAnons func(pkg *ssa.Package, f *ssa.Function)
+	addAnons = func(pkg *ssa.Package, f *ssa.Function) {
+		prog.SrcFuncs[pkg] = append(prog.SrcFuncs[pkg], f)
+		for _, anon := range f.AnonFuncs {
+			addAnons(pkg, anon)
+		}
+	}
+	for _, fn := range instances {
+		addAnons(generics[fn.Pos()], fn)
+	}
+}
+
+func isGeneric(fn *ssa.Function) bool {
+	return fn.Signature.TypeParams().Len() != 0 || fn.Signature.RecvTypeParams().Len() != 0
+}
+
+// outermost returns the function which is declared at the package level and encloses fn.
+func outermost(fn *ssa.Function) *ssa.Function {
+	for fn.Parent() != nil {
+		fn = fn.Parent()
+	}
+	return fn
+}
+
+func isInstance(fn *ssa.Function) bool {
+	return strings.HasPrefix(fn.Synthetic, "instance of ")
+}
+
+// instanceOf returns the instantiation of a generic function which fn belongs to.
+// It returns nil if fn is not a part of an instantiation.
+func instanceOf(fn *ssa.Function) *ssa.Function {
+	for ; fn != nil; fn = fn.Parent() {
+		if isInstance(fn) {
+			return fn
+		}
+	}
+	return nil
+}
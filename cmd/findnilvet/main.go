@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/gostaticanalysis/findnil/analyzer"
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() {
+	unitchecker.Main(analyzer.Analyzer)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gostaticanalysis/findnil/nilless"
+	"golang.org/x/tools/go/packages"
+)
+
+func main() {
+	if err := run(os.Args[1:], "", os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run runs nilless with args in dir, which is the current directory if it is empty.
+func run(args []string, dir string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("nilless", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: nilless [-o dir | -diff] [flags] [packages]")
+		flags.PrintDefaults()
+	}
+	var flagOut string
+	flags.StringVar(&flagOut, "o", "", "write a copy of the module whose nil values are replaced to the directory, which must not exist or be empty")
+	var flagDiff bool
+	flags.BoolVar(&flagDiff, "diff", false, "print the differences from the original sources in the unified format")
+	var flagTest bool
+	flags.BoolVar(&flagTest, "test", false, "rewrite test packages too")
+	var flagOffline bool
+	flags.BoolVar(&flagOffline, "offline", false, "load packages without network access using only the module cache and go.sum (GOPROXY=off); -mod=readonly rather than -mod=mod is added unless GOFLAGS has -mod or the module has a vendor directory, so go.mod and go.sum are never updated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case flagOut != "" && flagDiff:
+		return errors.New("-o and -diff cannot be specified at the same time")
+	case flagOut == "" && !flagDiff:
+		return errors.New("either -o or -diff must be specified")
+	}
+
+	cfg := &packages.Config{
+		Dir:  dir,
+		Fset: token.NewFileSet(),
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
+			packages.NeedTypes | packages.NeedDeps | packages.NeedModule | packages.NeedImports,
+		Tests: flagTest,
+	}
+	if flagOffline {
+		cfg.Env = nilless.OfflineEnv(nil, dir)
+	}
+	result, err := nilless.Load(cfg, flags.Args()...)
+	if err != nil {
+		return err
+	}
+
+	root, err := moduleRoot(result.Pkgs)
+	if err != nil {
+		return err
+	}
+
+	if flagDiff {
+		return result.Diff(stdout, root)
+	}
+
+	out := flagOut
+	if !filepath.IsAbs(out) && dir != "" {
+		out = filepath.Join(dir, out)
+	}
+	return writeModule(stderr, result, root, out)
+}
+
+// moduleRoot returns the directory of the main module which has pkgs.
+func moduleRoot(pkgs []*packages.Package) (string, error) {
+	var root string
+	for _, pkg := range pkgs {
+		if pkg.Module == nil || !pkg.Module.Main {
+			continue
+		}
+		if root != "" && root != pkg.Module.Dir {
+			return "", fmt.Errorf("packages of several modules are specified: %s and %s", root, pkg.Module.Dir)
+		}
+		root = pkg.Module.Dir
+	}
+
+	if root == "" {
+		return "", errors.New("packages are not in a main module")
+	}
+
+	return root, nil
+}
+
+// writeModule copies the module at root to dir and overwrites it with the rewritten files.
+// Rewritten files which are not in the module are reported to w and skipped.
+func writeModule(w io.Writer, result *nilless.Result, root, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	switch entries, err := os.ReadDir(dir); {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	case len(entries) != 0:
+		return fmt.Errorf("%s is not empty", dir)
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// the output directory may be in the module
+		if d.IsDir() && (d.Name() == ".git" || path == dir) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dst)
+		case d.Type().IsRegular():
+			return copyFile(dst, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// files are written in the order of their paths so that the messages are in the same order
+	paths := make([]string, 0, len(result.Overlay))
+	for path := range result.Overlay {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			fmt.Fprintln(w, "skipped", path, "which is not in", root)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), result.Overlay[path], 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(dst, src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
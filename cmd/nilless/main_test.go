@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tenntenn/golden"
+)
+
+var (
+	flagUpdate bool
+)
+
+func init() {
+	flag.BoolVar(&flagUpdate, "update", false, "update golden files")
+}
+
+// copyTestdata copies the module in testdata to root.
+func copyTestdata(t *testing.T, root, name string) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	err := filepath.WalkDir(filepath.Join("testdata", name), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Join("testdata", name), path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0o755)
+		}
+		return copyFile(filepath.Join(dir, rel), path)
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return dir
+}
+
+func TestRun_output(t *testing.T) {
+	dir := copyTestdata(t, t.TempDir(), "a")
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// the output directory in the module is not copied into itself
+	out := filepath.Join(dir, "out")
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"-o", "out", "./..."}, dir, &stdout, &stderr); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, &stderr)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("unexpected output:\n%s%s", &stdout, &stderr)
+	}
+
+	for _, name := range []string{".git", "out"} {
+		if _, err := os.Stat(filepath.Join(out, name)); !os.IsNotExist(err) {
+			t.Errorf("%s must not be copied: %v", name, err)
+		}
+	}
+
+	decls, err := filepath.Glob(filepath.Join(out, "nilless_decls_*.go"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(decls) == 0 {
+		t.Error("the declarations file was not written")
+	}
+
+	src, err := os.ReadFile(filepath.Join(out, "sub", "sub.go"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if strings.Contains(string(src), "return nil") {
+		t.Errorf("nil in sub.go was not replaced:\n%s", src)
+	}
+
+	// the module has the fake .git directory
+	cmd := exec.Command("go", "build", "-buildvcs=false", "./...")
+	cmd.Dir = out
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go build: %v\n%s", err, out)
+	}
+}
+
+func TestRun_outputOutside(t *testing.T) {
+	root := t.TempDir()
+	dir := copyTestdata(t, root, "outside")
+	dep := copyTestdata(t, root, "dep")
+
+	// the rewritten files of dep are not in the module of dir
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"-o", "out", "./...", "dep", "dep/x"}, dir, &stdout, &stderr); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, &stderr)
+	}
+
+	var want strings.Builder
+	for _, path := range []string{"dep.go", "nilless_decls_0.go", "x/nilless_decls_0.go", "x/x.go"} {
+		fmt.Fprintln(&want, "skipped", filepath.Join(dep, path), "which is not in", dir)
+	}
+	if stderr.String() != want.String() {
+		t.Errorf("stderr:\n%s\nwant:\n%s", &stderr, &want)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("unexpected stdout:\n%s", &stdout)
+	}
+}
+
+func TestRun_outputNotEmpty(t *testing.T) {
+	dir := copyTestdata(t, t.TempDir(), "a")
+	out := t.TempDir()
+	if err := os.WriteFile(filepath.Join(out, "file"), nil, 0o644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"-o", out, "./..."}, dir, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "is not empty") {
+		t.Errorf("want an error of the non-empty directory, got %v", err)
+	}
+}
+
+func TestRun_diff(t *testing.T) {
+	dir := copyTestdata(t, t.TempDir(), "a")
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"-diff", "./..."}, dir, &stdout, &stderr); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, &stderr)
+	}
+
+	if stderr.Len() != 0 {
+		t.Errorf("unexpected stderr:\n%s", &stderr)
+	}
+
+	testdata := filepath.Join("testdata", "golden")
+	if flagUpdate {
+		golden.Update(t, testdata, "diff", &stdout)
+		return
+	}
+
+	if diff := golden.Diff(t, testdata, "diff", &stdout); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRun_flags(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"none", []string{"./..."}},
+		{"both", []string{"-o", "out", "-diff", "./..."}},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := run(tt.args, t.TempDir(), &stdout, &stderr); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"a/sub"
+	"bytes"
+)
+
+func main() {
+	var b *bytes.Buffer
+	println(b.Len(), sub.F().N)
+}
@@ -0,0 +1,9 @@
+package sub
+
+type T struct {
+	N int
+}
+
+func F() *T {
+	return nil
+}
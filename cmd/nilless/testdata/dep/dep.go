@@ -0,0 +1,9 @@
+package dep
+
+type T struct {
+	N int
+}
+
+func F() *T {
+	return nil
+}
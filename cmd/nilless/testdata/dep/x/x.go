@@ -0,0 +1,9 @@
+package x
+
+type T struct {
+	N int
+}
+
+func F() *T {
+	return nil
+}
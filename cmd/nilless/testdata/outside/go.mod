@@ -0,0 +1,7 @@
+module outside
+
+go 1.17
+
+require dep v0.0.0
+
+replace dep => ../dep
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"dep"
+	"dep/x"
+)
+
+func main() {
+	println(dep.F().N, x.F().N)
+}
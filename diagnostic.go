@@ -0,0 +1,260 @@
+package findnil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Kind is a kind of findings.
+type Kind string
+
+const (
+	// KindSelector is a selection of a field or a method of a value which may be nil.
+	KindSelector Kind = "selector"
+	// KindMapAssign is an assignment to an entry in a map which may be nil.
+	KindMapAssign Kind = "map_assign"
+	// KindCall is a call of a function value which may be nil.
+	KindCall Kind = "call"
+	// KindDeref is an indirection, indexing or slicing of a pointer which may be nil.
+	KindDeref Kind = "deref"
+	// KindChanClose is a close of a channel which may be nil.
+	KindChanClose Kind = "chan_close"
+	// KindChanBlock is a send, a receive or a select which blocks forever
+	// because the channel may be nil.
+	KindChanBlock Kind = "chan_block"
+	// KindTypedNil is a conversion of a pointer which may be nil into an interface
+	// which is compared with nil.
+	KindTypedNil Kind = "typed_nil"
+)
+
+// Severity is a severity of findings.
+type Severity string
+
+const (
+	// SeverityError is a finding which causes a panic.
+	SeverityError Severity = "error"
+	// SeverityWarning is a finding which causes a problem other than a panic.
+	SeverityWarning Severity = "warning"
+)
+
+type rule struct {
+	id       string
+	name     string
+	desc     string
+	severity Severity
+}
+
+// rules must not be renumbered because rule IDs are referred by other tools.
+var rules = map[Kind]*rule{
+	KindSelector:  {"FN1001", "NilSelector", "Selecting a field or a method of a value which may be nil", SeverityError},
+	KindMapAssign: {"FN1002", "NilMapAssignment", "Assigning to an entry in a map which may be nil", SeverityError},
+	KindCall:      {"FN1003", "NilFuncCall", "Calling a function value which may be nil", SeverityError},
+	KindDeref:     {"FN1004", "NilDereference", "Dereferencing a pointer which may be nil", SeverityError},
+	KindChanClose: {"FN1005", "NilChannelClose", "Closing a channel which may be nil", SeverityError},
+	KindChanBlock: {"FN1006", "NilChannelBlock", "Sending to or receiving from a channel which may be nil", SeverityWarning},
+	KindTypedNil:  {"FN1007", "TypedNilInterface", "Converting a pointer which may be nil into an interface which is compared with nil", SeverityWarning},
+}
+
+// RuleID returns the stable identifier of the rule which reports findings of k.
+func (k Kind) RuleID() string {
+	if r := rules[k]; r != nil {
+		return r.id
+	}
+	return string(k)
+}
+
+// Severity returns the severity of findings of k.
+func (k Kind) Severity() Severity {
+	if r := rules[k]; r != nil {
+		return r.severity
+	}
+	return SeverityWarning
+}
+
+// Confidence is how likely a finding is a real bug.
+type Confidence int
+
+const (
+	// ConfidenceNone is lower than any confidence.
+	// It is used as a threshold which no finding reaches.
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is a finding which relies on an over-approximation of the pointer analysis.
+	ConfidenceLow
+	// ConfidenceMedium is a finding whose nil value flows across function calls.
+	ConfidenceMedium
+	// ConfidenceHigh is a finding whose nil value flows in a function or via package level variables.
+	ConfidenceHigh
+)
+
+var confidenceNames = [...]string{
+	ConfidenceNone:   "none",
+	ConfidenceLow:    "low",
+	ConfidenceMedium: "medium",
+	ConfidenceHigh:   "high",
+}
+
+func (c Confidence) String() string {
+	if 0 <= c && int(c) < len(confidenceNames) {
+		return confidenceNames[c]
+	}
+	return fmt.Sprintf("Confidence(%d)", int(c))
+}
+
+// Set implements flag.Value.
+func (c *Confidence) Set(s string) error {
+	return c.UnmarshalText([]byte(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Confidence) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Confidence) UnmarshalText(text []byte) error {
+	for i, name := range confidenceNames {
+		if name == string(text) {
+			*c = Confidence(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid confidence: %q", text)
+}
+
+// Diagnostic is a finding reported by findnil.
+type Diagnostic struct {
+	Kind       Kind       `json:"kind"`
+	Severity   Severity   `json:"severity"`
+	Confidence Confidence `json:"confidence"`
+	Package    string     `json:"package"`
+	File       string     `json:"file"`
+	Line       int        `json:"line"`
+	Column     int        `json:"column"`
+	EndLine    int        `json:"end_line"`
+	EndColumn  int        `json:"end_column"`
+	// Expr is the source text of the expression which may be nil.
+	Expr string `json:"expr"`
+	// ValueKind is the kind of the SSA value of Expr such as "UnOp" or "Call".
+	ValueKind string `json:"value_kind"`
+	// Reason describes why the value was judged nil.
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	// Flow is the flow of the nil value from its origin to Expr.
+	Flow []*FlowStep `json:"flow,omitempty"`
+}
+
+// FlowStep is a step of a flow of a nil value.
+// File is empty if the step has no position in the source such as an implicit load.
+type FlowStep struct {
+	// File, Line and Column are omitted if the step has no position.
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+	// SSA is the SSA value or instruction of the step, which is set by -explain.
+	SSA string `json:"ssa,omitempty"`
+	// Note describes how the step is derived such as a kind of a call, which is set by -explain.
+	Note string `json:"note,omitempty"`
+}
+
+// Posn returns the position of s in the form of "file:line:column".
+// It returns an empty string if s has no position.
+func (s *FlowStep) Posn() string {
+	if s.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Column)
+}
+
+// Posn returns the position of d in the form of "file:line:column".
+func (d *Diagnostic) Posn() string {
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+// writeSummary writes the numbers of findings per package and per kind.
+func writeSummary(w io.Writer, diags []*Diagnostic) error {
+	pkgs := make(map[string]int)
+	kinds := make(map[string]int)
+	for _, d := range diags {
+		pkgs[d.Package]++
+		kinds[string(d.Kind)]++
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d findings\n", len(diags))
+	for _, counts := range []struct {
+		title string
+		m     map[string]int
+	}{
+		{"package", pkgs},
+		{"kind", kinds},
+	} {
+		keys := make([]string, 0, len(counts.m))
+		for key := range counts.m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&buf, "by %s:\n", counts.title)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "\t%s: %d\n", key, counts.m[key])
+		}
+	}
+
+	_, err := io.Copy(w, &buf)
+	return err
+}
+
+type renderer interface {
+	Render(w io.Writer, diags []*Diagnostic) error
+}
+
+// textRenderer renders diagnostics as human readable lines.
+// The flows of nil values are also rendered if explain is true.
+type textRenderer struct {
+	explain bool
+}
+
+func (r textRenderer) Render(w io.Writer, diags []*Diagnostic) error {
+	var buf bytes.Buffer
+	for _, d := range diags {
+		fmt.Fprintf(&buf, "%s %s\n", d.Posn(), d.Message)
+		if !r.explain {
+			continue
+		}
+
+		for _, step := range d.Flow {
+			posn := step.Posn()
+			if posn == "" {
+				posn = "(no position)"
+			}
+			fmt.Fprintf(&buf, "\t%s %s\n", posn, step.Message)
+
+			switch {
+			case step.SSA != "" && step.Note != "":
+				fmt.Fprintf(&buf, "\t\t%s (%s)\n", step.SSA, step.Note)
+			case step.SSA != "":
+				fmt.Fprintf(&buf, "\t\t%s\n", step.SSA)
+			}
+		}
+	}
+
+	_, err := io.Copy(w, &buf)
+	return err
+}
+
+// jsonRenderer renders diagnostics as JSON Lines, one object per finding.
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(w io.Writer, diags []*Diagnostic) error {
+	enc := json.NewEncoder(w)
+	for _, d := range diags {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,27 +2,34 @@ package findnil
 
 import (
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/token"
-	"go/types"
 	"io"
 	"os"
 	"sort"
+	"strings"
 
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"github.com/gostaticanalysis/findnil/internal/pointer"
 	"github.com/gostaticanalysis/findnil/nilless"
-	"golang.org/x/tools/go/ast/inspector"
 	"golang.org/x/tools/go/packages"
-	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 )
 
 const (
 	ExitSuccess = 0
 	ExitError   = 1
+	// ExitFound is returned when findings which reach the threshold of -fail-on are reported.
+	ExitFound = 2
 )
 
+// errFound is returned by Cmd.run when findings reach the threshold.
+var errFound = errors.New("nil dereferences are found")
+
 func Main(args ...string) int {
 	cmd := &Cmd{
 		Stdout: os.Stdout,
@@ -39,6 +46,9 @@ type Cmd struct {
 
 func (cmd *Cmd) Run(args ...string) int {
 	if err := cmd.run(args); err != nil {
+		if errors.Is(err, errFound) {
+			return ExitFound
+		}
 		fmt.Fprintln(cmd.Stderr, "Error:", err)
 		return ExitError
 	}
@@ -46,13 +56,53 @@ func (cmd *Cmd) Run(args ...string) int {
 }
 
 func (cmd *Cmd) run(args []string) error {
+	flags := flag.NewFlagSet("findnil", flag.ContinueOnError)
+	flags.SetOutput(cmd.Stderr)
+	var flagJSON, flagSARIF bool
+	flags.BoolVar(&flagJSON, "json", false, "emit findings as JSON Lines")
+	flags.BoolVar(&flagSARIF, "sarif", false, "emit findings as a SARIF 2.1.0 log")
+	var flagLib bool
+	flags.BoolVar(&flagLib, "lib", false, "library mode: use exported functions and methods as analysis roots")
+	var flagTest bool
+	flags.BoolVar(&flagTest, "test", false, "analyze test packages and use test mains as analysis roots")
+	failOn := ConfidenceLow
+	flags.Var(&failOn, "fail-on", "minimum confidence of findings which make the exit status non-zero: low, medium, high or none")
+	flagNilParams := string(ParamPolicyPointer)
+	flags.StringVar(&flagNilParams, "nilparams", flagNilParams, "parameters of exported functions which may be nil in the library mode: none, pointer or all")
+	var flagOffline bool
+	flags.BoolVar(&flagOffline, "offline", false, "load packages without network access using only the module cache and go.sum (GOPROXY=off); -mod=readonly rather than -mod=mod is added unless GOFLAGS has -mod or the module has a vendor directory, so go.mod and go.sum are never updated")
+	var flagExplain bool
+	flags.BoolVar(&flagExplain, "explain", false, "show the flow of the nil value of each finding with its SSA values and instructions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	policy := ParamPolicy(flagNilParams)
+	if !policy.valid() {
+		return fmt.Errorf("invalid -nilparams: %q", flagNilParams)
+	}
+
+	var r renderer = textRenderer{explain: flagExplain}
+	switch {
+	case flagJSON && flagSARIF:
+		return errors.New("-json and -sarif cannot be specified at the same time")
+	case flagJSON:
+		r = jsonRenderer{}
+	case flagSARIF:
+		r = sarifRenderer{}
+	}
+
 	cfg := &packages.Config{
 		Dir:  cmd.Dir,
 		Fset: token.NewFileSet(),
 		Mode: packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
-			packages.NeedTypes | packages.NeedDeps | packages.NeedModule,
+			packages.NeedTypes | packages.NeedDeps | packages.NeedModule | packages.NeedImports,
+		Tests: flagTest,
 	}
-	result, err := nilless.Load(cfg, args...)
+	if flagOffline {
+		cfg.Env = nilless.OfflineEnv(nil, cmd.Dir)
+	}
+	result, err := nilless.Load(cfg, flags.Args()...)
 	if err != nil {
 		return err
 	}
@@ -62,97 +112,200 @@ func (cmd *Cmd) run(args []string) error {
 		return err
 	}
 
-	if err := cmd.analyze(prog); err != nil {
+	if flagLib {
+		if err := prog.addLibraryMain(policy); err != nil {
+			return err
+		}
+	}
+
+	diags, err := cmd.analyze(prog, flagExplain)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Render(cmd.Stdout, diags); err != nil {
 		return err
 	}
 
+	if len(diags) != 0 {
+		if err := writeSummary(cmd.Stderr, diags); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range diags {
+		if failOn != ConfidenceNone && d.Confidence >= failOn {
+			return errFound
+		}
+	}
+
 	return nil
 }
 
-func (cmd *Cmd) analyze(prog *Program) error {
+// analyze returns the findings in the program.
+// The flows of the findings have all the steps if explain is true.
+func (cmd *Cmd) analyze(prog *Program, explain bool) ([]*Diagnostic, error) {
 
 	config := &pointer.Config{
-		Mains: prog.Mains,
+		Mains:          prog.Mains,
+		BuildCallGraph: true,
 	}
 
-	var nodes []ast.Node
-	node2pkg := make(map[ast.Node]*types.Package)
-	node2value := make(map[ast.Node]ssa.Value)
-
-	for _, pkg := range prog.Packages {
-		inspect := inspector.New(prog.Files[pkg])
-		filter := []ast.Node{(*ast.SelectorExpr)(nil)}
-		inspect.WithStack(filter, func(n ast.Node, push bool, stack []ast.Node) (proceed bool) {
-			if !push {
-				return false
-			}
+	var sites []*site
+	for _, pkg := range prog.Initial {
+		sites = append(sites, collectSites(prog, pkg)...)
+	}
 
-			sel, _ := n.(*ast.SelectorExpr)
-			if sel == nil {
-				return true
-			}
+	// the pointer analysis builds the call graph
+	// and tells which pointers may be aliases of the addresses of loads
+	prog.addAliasQueries(config)
+	result, err := pointer.Analyze(config)
+	if err != nil {
+		return nil, err
+	}
+	prog.CallGraph = result.CallGraph
+	prog.PointsTo = result.Queries
+
+	sortSites(prog, sites)
+	var diags []*Diagnostic
+	memo := newNilMemo()
+	// a package and its test variant share the same source files
+	reported := make(map[string]bool)
+	for _, s := range sites {
+		v := s.value
+		if instr, _ := v.(ssa.Instruction); instr != nil && nilcheck.IsGuarded(v, instr) {
+			continue
+		}
 
-			typ := prog.TypesInfo[pkg].TypeOf(sel.X)
-			if !pointer.CanPoint(typ) {
-				return false
-			}
+		flow := isNil(prog, memo, v)
+		if flow == nil || !allNil(prog, memo, s.others) {
+			continue
+		}
 
-			f := ssa.EnclosingFunction(pkg, stackToPath(stack))
-			if f == nil {
-				return false
+		var cmp token.Pos
+		if s.iface != nil {
+			if cmp = comparedWithNil(prog, s.iface, make(map[ssa.Value]bool)); !cmp.IsValid() {
+				continue
 			}
+		}
 
-			v, _ := f.ValueForExpr(sel.X)
-			if v == nil {
-				return false
-			}
+		msg, step := s.message(prog)
+		if s.instance != nil {
+			msg += " in " + s.instance.Name()
+		}
+		flow = &nilFlow{prev: flow, pos: s.node.Pos(), msg: step}
+		if cmp.IsValid() {
+			flow = &nilFlow{prev: flow, pos: cmp, msg: "compared with nil"}
+		}
+		d := newDiagnostic(prog, s.kind, s.pkg.Path(), s.node, v, flow, msg, explain)
+		if key := d.Posn() + " " + d.Message; !reported[key] {
+			reported[key] = true
+			diags = append(diags, d)
+		}
+	}
 
-			nodes = append(nodes, sel)
-			node2pkg[sel] = f.Package().Pkg
-			node2value[sel] = v
-			config.AddQuery(v)
+	return diags, nil
+}
 
-			return true
-		})
+// sortSites sorts sites by the file, the line and the column of their nodes in the original source,
+// so that findings are reported in the same order and with the same flows across runs.
+// Sites at the same position are sorted by their ends, kinds and instantiations.
+func sortSites(prog *Program, sites []*site) {
+	type key struct {
+		pos, end token.Position
+		kind     Kind
+		instance string
 	}
-
-	result, err := pointer.Analyze(config)
-	if err != nil {
-		return err
+	keys := make(map[*site]key, len(sites))
+	for _, s := range sites {
+		k := key{pos: position(prog, s.node.Pos()), end: position(prog, s.node.End()), kind: s.kind}
+		if s.instance != nil {
+			k.instance = s.instance.Name()
+		}
+		keys[s] = k
 	}
 
-	nils := make(map[ssa.Value]bool)
-	done := make(map[ssa.Value]bool)
-	for v, p := range result.Queries {
-		if isNil(prog, done, v) {
-			nils[v] = true
+	sort.SliceStable(sites, func(i, j int) bool {
+		ki, kj := keys[sites[i]], keys[sites[j]]
+		switch {
+		case ki.pos != kj.pos:
+			return positionLess(ki.pos, kj.pos)
+		case ki.end != kj.end:
+			return positionLess(ki.end, kj.end)
+		case ki.kind != kj.kind:
+			return ki.kind < kj.kind
 		}
+		return ki.instance < kj.instance
+	})
+}
 
-		for _, l := range p.PointsTo().Labels() {
-			lv := l.Value()
-			if !(nils[v] && nils[lv]) || isNil(prog, done, lv) {
-				nils[v] = true
-				nils[lv] = true
-			}
+// positionLess reports whether p is before q in the order of files, lines and columns.
+func positionLess(p, q token.Position) bool {
+	switch {
+	case p.Filename != q.Filename:
+		return p.Filename < q.Filename
+	case p.Line != q.Line:
+		return p.Line < q.Line
+	}
+	return p.Column < q.Column
+}
+
+// allNil reports whether all the values may be nil.
+func allNil(prog *Program, memo *nilMemo, vs []ssa.Value) bool {
+	for _, v := range vs {
+		if isNil(prog, memo, v) == nil {
+			return false
 		}
 	}
+	return true
+}
 
-	sort.Slice(nodes, func(i, j int) bool {
-		return nodes[i].Pos() < nodes[i].Pos()
-	})
-	for _, n := range nodes {
-		v := node2value[n]
-		if !nils[v] {
+func newDiagnostic(prog *Program, kind Kind, pkg string, n ast.Node, v ssa.Value, flow *nilFlow, msg string, explain bool) *Diagnostic {
+	pos, end := position(prog, n.Pos()), position(prog, n.End())
+	origin := flow.origin()
+	d := &Diagnostic{
+		Kind:       kind,
+		Severity:   kind.Severity(),
+		Confidence: flow.confidence(),
+		Package:    pkg,
+		File:       pos.Filename,
+		Line:       pos.Line,
+		Column:     pos.Column,
+		EndLine:    end.Line,
+		EndColumn:  end.Column,
+		Expr:       exprString(prog, n),
+		ValueKind:  strings.TrimPrefix(fmt.Sprintf("%T", v), "*ssa."),
+		Reason:     fmt.Sprintf("%s at %s", origin.msg, position(prog, origin.pos)),
+		Message:    msg,
+	}
+
+	for _, step := range flow.steps() {
+		if !explain && (step.detail || !step.pos.IsValid()) {
 			continue
 		}
-
-		pkg := node2pkg[n].Path()
-		var buf bytes.Buffer
-		format.Node(&buf, prog.Fset, n)
-		fmt.Fprintf(cmd.Stdout, "%s %s may be nil\n", fileline(prog, pkg, n.Pos()), &buf)
+		pos := position(prog, step.pos)
+		if explain {
+			pos = position(prog, step.position())
+		}
+		fs := &FlowStep{
+			File:    pos.Filename,
+			Line:    pos.Line,
+			Column:  pos.Column,
+			Message: step.msg,
+		}
+		if explain {
+			fs.SSA, fs.Note = step.ssa(), step.note
+		}
+		d.Flow = append(d.Flow, fs)
 	}
 
-	return nil
+	return d
+}
+
+func exprString(prog *Program, n ast.Node) string {
+	var buf bytes.Buffer
+	format.Node(&buf, prog.Fset, n)
+	return buf.String()
 }
 
 func stackToPath(stack []ast.Node) []ast.Node {
@@ -171,62 +324,8 @@ func refs(v ssa.Value) []ssa.Instruction {
 	return *refsptr
 }
 
-func isNil(prog *Program, done map[ssa.Value]bool, v ssa.Value) bool {
-	if done[v] {
-		return false
-	}
-	done[v] = true
-
-	if isNilGlobal(prog, v) {
-		return true
-	}
-
-	for _, ref := range refs(v) {
-		switch ref := ref.(type) {
-		case *ssa.DebugRef:
-			id, _ := ref.Expr.(*ast.Ident)
-			if id == nil {
-				continue
-			}
-
-			if prog.Nilless.IsNil[id.Name] {
-				return true
-			}
-		case *ssa.Store:
-			return isNil(prog, done, ref.Val)
-		}
-	}
-
-	switch v := v.(type) {
-	case *ssa.UnOp:
-		return isNil(prog, done, v.X)
-	}
-
-	return false
-}
-
-func isNilGlobal(prog *Program, v ssa.Value) bool {
-	switch v := v.(type) {
-	case *ssa.UnOp:
-		return isNilGlobal(prog, v.X)
-	case *ssa.Global:
-		for _, init := range prog.TypesInfo[v.Pkg].InitOrder {
-			if len(init.Lhs) != 1 || v.Object() != init.Lhs[0] {
-				continue
-			}
-
-			id, _ := init.Rhs.(*ast.Ident)
-			if id != nil && prog.Nilless.IsNil[id.Name] {
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
-func fileline(prog *Program, pkg string, p token.Pos) string {
-	pos := prog.Fset.Position(p)
-	fname := prog.Nilless.Base(pos.Filename)
-	return fmt.Sprintf("%s:%d:%d", fname, pos.Line, pos.Column)
+func position(prog *Program, p token.Pos) token.Position {
+	pos := prog.Nilless.Position(p)
+	pos.Filename = prog.Nilless.Base(pos.Filename)
+	return pos
 }
@@ -21,15 +21,51 @@ func init() {
 func TestCmd_Run(t *testing.T) {
 	t.Parallel()
 	cases := []struct {
+		name         string
 		pkg          string
+		args         []string
 		wantExitcode int
 	}{
-		{"a", findnil.ExitSuccess},
+		{"a", "a", nil, findnil.ExitFound},
+		{"a_json", "a", []string{"-json"}, findnil.ExitFound},
+		{"a_sarif", "a", []string{"-sarif"}, findnil.ExitFound},
+		{"a_fail_on_high", "a", []string{"-fail-on=high"}, findnil.ExitFound},
+		{"a_fail_on_none", "a", []string{"-fail-on=none"}, findnil.ExitSuccess},
+		{"json_and_sarif", "a", []string{"-json", "-sarif"}, findnil.ExitError},
+		{"a_offline", "a", []string{"-offline"}, findnil.ExitFound},
+		{"a_explain", "a", []string{"-explain"}, findnil.ExitFound},
+		{"a_explain_json", "a", []string{"-explain", "-json"}, findnil.ExitFound},
+		{"lib", "lib", []string{"-lib"}, findnil.ExitFound},
+		{"lib_all", "lib", []string{"-lib", "-nilparams=all"}, findnil.ExitFound},
+		{"lib_none", "lib", []string{"-lib", "-nilparams=none", "-fail-on=medium"}, findnil.ExitSuccess},
+		{"lib_invalid_policy", "lib", []string{"-lib", "-nilparams=invalid"}, findnil.ExitError},
+		{"tests", "tests", []string{"-test"}, findnil.ExitFound},
+		{"args", "args", nil, findnil.ExitFound},
+		{"cycles", "cycles", nil, findnil.ExitFound},
+		{"alias", "alias", nil, findnil.ExitFound},
+		{"alias_json", "alias", []string{"-json"}, findnil.ExitFound},
+		{"guard", "guard", nil, findnil.ExitFound},
+		{"maps", "maps", nil, findnil.ExitFound},
+		{"funcs", "funcs", nil, findnil.ExitFound},
+		{"funcs_explain", "funcs", []string{"-explain"}, findnil.ExitFound},
+		{"deref", "deref", nil, findnil.ExitFound},
+		{"chans", "chans", nil, findnil.ExitFound},
+		{"typednil", "typednil", nil, findnil.ExitFound},
+		{"typednil_json", "typednil", []string{"-json"}, findnil.ExitFound},
+		{"recv", "recv", nil, findnil.ExitFound},
+		{"assign", "assign", nil, findnil.ExitFound},
+		{"complit", "complit", nil, findnil.ExitFound},
+		{"naked", "naked", nil, findnil.ExitFound},
+		{"imports", "imports", nil, findnil.ExitFound},
+		{"imports_json", "imports", []string{"-json"}, findnil.ExitFound},
+		{"generics", "generics", nil, findnil.ExitFound},
+		{"generics_json", "generics", []string{"-json"}, findnil.ExitFound},
+		{"unnameable", "unnameable", nil, findnil.ExitFound},
 	}
 
 	for _, tt := range cases {
 		tt := tt
-		t.Run(tt.pkg, func(t *testing.T) {
+		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			var stdout, stderr bytes.Buffer
 			cmd := &findnil.Cmd{
@@ -38,20 +74,70 @@ func TestCmd_Run(t *testing.T) {
 				Stderr: &stderr,
 			}
 
-			got := cmd.Run("./...")
+			got := cmd.Run(append(tt.args, "./...")...)
 			if got != tt.wantExitcode {
 				t.Fatalf("exitcode: want %d, got %d with %s", tt.wantExitcode, got, &stderr)
 			}
 
 			testdata := filepath.Join("testdata", "golden")
 			if flagUpdate {
-				golden.Update(t, testdata, tt.pkg, &stdout)
+				golden.Update(t, testdata, tt.name, &stdout)
 				return
 			}
 
-			if diff := golden.Diff(t, testdata, tt.pkg, &stdout); diff != "" {
+			if diff := golden.Diff(t, testdata, tt.name, &stdout); diff != "" {
 				t.Error(diff)
 			}
 		})
 	}
 }
+
+func TestCmd_Run_summary(t *testing.T) {
+	t.Parallel()
+	var stdout, stderr bytes.Buffer
+	cmd := &findnil.Cmd{
+		Dir:    filepath.Join("testdata", "a"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+
+	if got := cmd.Run("./..."); got != findnil.ExitFound {
+		t.Fatalf("exitcode: want %d, got %d with %s", findnil.ExitFound, got, &stderr)
+	}
+
+	testdata := filepath.Join("testdata", "golden")
+	if flagUpdate {
+		golden.Update(t, testdata, "a_summary", &stderr)
+		return
+	}
+
+	if diff := golden.Diff(t, testdata, "a_summary", &stderr); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCmd_Run_deterministic(t *testing.T) {
+	t.Parallel()
+	// instantiations of generic functions are created in the order of maps
+	var want string
+	for i := 0; i < 5; i++ {
+		var stdout, stderr bytes.Buffer
+		cmd := &findnil.Cmd{
+			Dir:    filepath.Join("testdata", "generics"),
+			Stdout: &stdout,
+			Stderr: &stderr,
+		}
+
+		if got := cmd.Run("-json", "-explain", "./..."); got != findnil.ExitFound {
+			t.Fatalf("exitcode: want %d, got %d with %s", findnil.ExitFound, got, &stderr)
+		}
+
+		if i == 0 {
+			want = stdout.String()
+			continue
+		}
+		if got := stdout.String(); got != want {
+			t.Fatalf("run %d differs from the first run:\n%s\nfirst:\n%s", i, got, want)
+		}
+	}
+}
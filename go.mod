@@ -1,19 +1,19 @@
 module github.com/gostaticanalysis/findnil
 
-go 1.18
+go 1.26.0
 
 require (
 	github.com/tenntenn/golden v0.2.0
 	go.uber.org/multierr v1.7.0
-	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4
-	golang.org/x/tools v0.1.11-0.20220602222206-af82757ce069
+	golang.org/x/tools v0.50.0
+	golang.org/x/tools/go/expect v0.1.1-deprecated
 )
 
 require (
-	github.com/google/go-cmp v0.5.6 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/josharian/mapfs v0.0.0-20210615234106-095c008854e6 // indirect
 	github.com/josharian/txtarfs v0.0.0-20210615234325-77aca6df5bca // indirect
 	go.uber.org/atomic v1.7.0 // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
 )
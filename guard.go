@@ -0,0 +1,257 @@
+package findnil
+
+import (
+	"go/token"
+	"go/types"
+	"sort"
+
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"golang.org/x/tools/go/ssa"
+)
+
+// storeSet is a set of stores to a local variable.
+type storeSet map[*ssa.Store]bool
+
+// storeSets maps local variables to the stores which may have set their current values.
+type storeSets map[*ssa.Alloc]storeSet
+
+func (s storeSets) clone() storeSets {
+	c := make(storeSets, len(s))
+	for alloc, stores := range s {
+		c[alloc] = stores
+	}
+	return c
+}
+
+// union adds stores in t to s and reports whether s has been changed.
+func (s storeSets) union(t storeSets) bool {
+	var changed bool
+	for alloc, stores := range t {
+		merged, cloned := s[alloc], false
+		for store := range stores {
+			if merged[store] {
+				continue
+			}
+			// sets may be shared with other blocks
+			if !cloned {
+				merged, cloned = cloneStoreSet(merged), true
+				s[alloc] = merged
+			}
+			merged[store] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func cloneStoreSet(s storeSet) storeSet {
+	c := make(storeSet, len(s)+1)
+	for store := range s {
+		c[store] = true
+	}
+	return c
+}
+
+// reaching is the result of a reaching definitions analysis of local variables in a function.
+// Each set of stores at the entry of a block is refined by nil checks on the edges to the block:
+// a variable has no nil stores after "if v != nil",
+// and results of a call are not nil after "if err != nil { return }" on the error of the call.
+type reaching struct {
+	tracked map[*ssa.Alloc]bool
+	in      map[*ssa.BasicBlock]storeSets
+}
+
+// reachingStores returns the stores to the local variable which may be loaded by load.
+// It reports false if the address of load is not a local variable tracked by the analysis.
+func (prog *Program) reachingStores(load *ssa.UnOp) ([]*ssa.Store, bool) {
+	alloc, _ := load.X.(*ssa.Alloc)
+	fn := load.Parent()
+	if alloc == nil || fn == nil || load.Block() == nil {
+		return nil, false
+	}
+
+	if prog.reaching == nil {
+		prog.reaching = make(map[*ssa.Function]*reaching)
+	}
+	r := prog.reaching[fn]
+	if r == nil {
+		r = newReaching(fn)
+		prog.reaching[fn] = r
+	}
+
+	if !r.tracked[alloc] {
+		return nil, false
+	}
+
+	var stores []*ssa.Store
+	for store := range r.in[load.Block()][alloc] {
+		stores = append(stores, store)
+	}
+	for _, instr := range load.Block().Instrs {
+		if instr == load {
+			break
+		}
+		if store, _ := instr.(*ssa.Store); store != nil && store.Addr == alloc {
+			stores = []*ssa.Store{store}
+		}
+	}
+	sortStores(stores)
+
+	return stores, true
+}
+
+func newReaching(fn *ssa.Function) *reaching {
+	r := &reaching{
+		tracked: make(map[*ssa.Alloc]bool),
+		in:      make(map[*ssa.BasicBlock]storeSets),
+	}
+
+	for _, alloc := range fn.Locals {
+		if nilcheck.IsTrackable(alloc) {
+			r.tracked[alloc] = true
+		}
+	}
+
+	if len(fn.Blocks) == 0 || len(r.tracked) == 0 {
+		return r
+	}
+
+	entry := fn.Blocks[0]
+	r.in[entry] = make(storeSets)
+	queued := map[*ssa.BasicBlock]bool{entry: true}
+	queue := []*ssa.BasicBlock{entry}
+	for len(queue) != 0 {
+		b := queue[0]
+		queue = queue[1:]
+		queued[b] = false
+
+		out := r.in[b].clone()
+		for _, instr := range b.Instrs {
+			if store, _ := instr.(*ssa.Store); store != nil {
+				if alloc, _ := store.Addr.(*ssa.Alloc); r.tracked[alloc] {
+					out[alloc] = storeSet{store: true}
+				}
+			}
+		}
+
+		for _, succ := range b.Succs {
+			in, visited := r.in[succ]
+			if !visited {
+				in = make(storeSets)
+				r.in[succ] = in
+			}
+			if (in.union(r.refine(b, succ, out)) || !visited) && !queued[succ] {
+				queued[succ] = true
+				queue = append(queue, succ)
+			}
+		}
+	}
+
+	return r
+}
+
+// refine returns the sets of stores on the edge from b to succ.
+func (r *reaching) refine(b, succ *ssa.BasicBlock, out storeSets) storeSets {
+	ifInstr, _ := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+	if ifInstr == nil || b.Succs[0] == b.Succs[1] {
+		return out
+	}
+
+	op, x := nilcheck.Comparison(ifInstr.Cond)
+	load, _ := x.(*ssa.UnOp)
+	if load == nil || load.Op != token.MUL || load.Block() != b {
+		return out
+	}
+	alloc, _ := load.X.(*ssa.Alloc)
+	if !r.tracked[alloc] || storedAfter(alloc, load) {
+		return out
+	}
+
+	nonNil := b.Succs[0]
+	if op == token.EQL {
+		nonNil = b.Succs[1]
+	}
+
+	if succ == nonNil {
+		refined := out.clone()
+		refined[alloc] = nil
+		return refined
+	}
+
+	if !isError(alloc.Type().(*types.Pointer).Elem()) {
+		return out
+	}
+
+	// v, err := f(); if err != nil { return }
+	// results of f are not nil when err is nil
+	calls := make(map[ssa.Value]bool)
+	for store := range out[alloc] {
+		if extract, _ := store.Val.(*ssa.Extract); extract != nil {
+			calls[extract.Tuple] = true
+		}
+	}
+	if len(calls) == 0 {
+		return out
+	}
+
+	refined := out.clone()
+	for other, stores := range out {
+		if other == alloc {
+			continue
+		}
+		var s storeSet
+		for store := range stores {
+			if extract, _ := store.Val.(*ssa.Extract); extract != nil && calls[extract.Tuple] {
+				continue
+			}
+			if s == nil {
+				s = make(storeSet)
+			}
+			s[store] = true
+		}
+		refined[other] = s
+	}
+	return refined
+}
+
+// storedAfter reports whether alloc is stored after load in the block of load.
+func storedAfter(alloc *ssa.Alloc, load *ssa.UnOp) bool {
+	var after bool
+	for _, instr := range load.Block().Instrs {
+		if instr == load {
+			after = true
+			continue
+		}
+		if store, _ := instr.(*ssa.Store); after && store != nil && store.Addr == alloc {
+			return true
+		}
+	}
+	return false
+}
+
+func isError(typ types.Type) bool {
+	return types.Identical(typ, types.Universe.Lookup("error").Type())
+}
+
+// sortStores sorts stores by their positions.
+// Implicit stores which have no positions are sorted by their blocks and their indexes in the blocks.
+func sortStores(stores []*ssa.Store) {
+	index := func(store *ssa.Store) int {
+		for i, instr := range store.Block().Instrs {
+			if instr == store {
+				return i
+			}
+		}
+		return -1
+	}
+	sort.SliceStable(stores, func(i, j int) bool {
+		si, sj := stores[i], stores[j]
+		if si.Pos() != sj.Pos() {
+			return si.Pos() < sj.Pos()
+		}
+		if bi, bj := si.Block().Index, sj.Block().Index; bi != bj {
+			return bi < bj
+		}
+		return index(si) < index(sj)
+	})
+}
@@ -0,0 +1,145 @@
+// Package nilcheck finds nil checks in SSA functions.
+// It is shared by findnil.Cmd and the analyzer package.
+package nilcheck
+
+import (
+	"go/token"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// IsGuarded reports whether instr is executed only when v is not nil.
+// instr is guarded when its block is dominated by the edge of
+// "if v != nil" to the then block or "if v == nil" to the else block,
+// and the address which v is loaded from is not stored after the check.
+func IsGuarded(v ssa.Value, instr ssa.Instruction) bool {
+	if instr.Block() == nil {
+		return false
+	}
+
+	for b := instr.Block(); b.Idom() != nil; b = b.Idom() {
+		idom := b.Idom()
+		if len(b.Preds) != 1 || len(idom.Instrs) == 0 {
+			continue
+		}
+
+		ifInstr, _ := idom.Instrs[len(idom.Instrs)-1].(*ssa.If)
+		if ifInstr == nil || idom.Succs[0] == idom.Succs[1] {
+			continue
+		}
+
+		op, x := Comparison(ifInstr.Cond)
+		if x == nil || !SameValue(x, v) {
+			continue
+		}
+
+		switch {
+		case op == token.NEQ && b == idom.Succs[0],
+			op == token.EQL && b == idom.Succs[1]:
+			return !storedBetween(v, b, instr)
+		}
+	}
+	return false
+}
+
+// storedBetween reports whether the address which v is loaded from
+// may be stored in blocks dominated by b before instr.
+// Calls may store it too unless it is a local variable whose address does not escape.
+func storedBetween(v ssa.Value, b *ssa.BasicBlock, instr ssa.Instruction) bool {
+	load, _ := v.(*ssa.UnOp)
+	if load == nil || load.Op != token.MUL {
+		return false
+	}
+	local, _ := load.X.(*ssa.Alloc)
+	if local != nil && !IsTrackable(local) {
+		local = nil
+	}
+
+	for _, blk := range instr.Parent().Blocks {
+		if !b.Dominates(blk) {
+			continue
+		}
+		for _, i := range blk.Instrs {
+			if i == instr {
+				break
+			}
+			switch i := i.(type) {
+			case *ssa.Store:
+				if SameValue(i.Addr, load.X) {
+					return true
+				}
+			case *ssa.Call:
+				if _, builtin := i.Call.Value.(*ssa.Builtin); !builtin && local == nil {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// IsTrackable reports whether the local variable is accessed only by loads and stores.
+// A variable whose address escapes may be changed behind the analysis.
+func IsTrackable(alloc *ssa.Alloc) bool {
+	for _, ref := range *alloc.Referrers() {
+		switch ref := ref.(type) {
+		case *ssa.Store:
+			if ref.Val == alloc {
+				return false
+			}
+		case *ssa.UnOp:
+			if ref.Op != token.MUL {
+				return false
+			}
+		case *ssa.DebugRef:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SameValue reports whether x and y are the same value
+// or loads of the same address which is computed in the same way.
+func SameValue(x, y ssa.Value) bool {
+	if x == y {
+		return true
+	}
+
+	switch x := x.(type) {
+	case *ssa.UnOp:
+		y, _ := y.(*ssa.UnOp)
+		return y != nil && x.Op == token.MUL && y.Op == token.MUL && SameValue(x.X, y.X)
+	case *ssa.FieldAddr:
+		y, _ := y.(*ssa.FieldAddr)
+		return y != nil && x.Field == y.Field && SameValue(x.X, y.X)
+	case *ssa.Field:
+		y, _ := y.(*ssa.Field)
+		return y != nil && x.Field == y.Field && SameValue(x.X, y.X)
+	}
+
+	return false
+}
+
+// Comparison returns the operator and the operand compared with nil if cond compares a value with nil.
+func Comparison(cond ssa.Value) (token.Token, ssa.Value) {
+	binop, _ := cond.(*ssa.BinOp)
+	if binop == nil || (binop.Op != token.EQL && binop.Op != token.NEQ) {
+		return token.ILLEGAL, nil
+	}
+
+	isNilConst := func(v ssa.Value) bool {
+		c, _ := v.(*ssa.Const)
+		return c != nil && c.IsNil()
+	}
+
+	switch {
+	case isNilConst(binop.Y):
+		return binop.Op, binop.X
+	case isNilConst(binop.X):
+		return binop.Op, binop.Y
+	}
+
+	return token.ILLEGAL, nil
+}
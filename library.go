@@ -0,0 +1,322 @@
+package findnil
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// ParamPolicy decides which parameters of exported functions and methods
+// may be nil in the library mode.
+type ParamPolicy string
+
+const (
+	// ParamPolicyNone treats no parameters as nil.
+	ParamPolicyNone ParamPolicy = "none"
+	// ParamPolicyPointer treats parameters of pointer types as nil.
+	ParamPolicyPointer ParamPolicy = "pointer"
+	// ParamPolicyAll treats parameters of all types which can be nil as nil.
+	ParamPolicyAll ParamPolicy = "all"
+)
+
+func (p ParamPolicy) valid() bool {
+	switch p {
+	case ParamPolicyNone, ParamPolicyPointer, ParamPolicyAll:
+		return true
+	}
+	return false
+}
+
+// allows reports whether a parameter of typ may be nil under the policy.
+func (p ParamPolicy) allows(typ types.Type) bool {
+	switch p {
+	case ParamPolicyPointer:
+		_, ok := typ.Underlying().(*types.Pointer)
+		return ok
+	case ParamPolicyAll:
+		switch typ.Underlying().(type) {
+		case *types.Pointer, *types.Interface, *types.Map, *types.Slice,
+			*types.Chan, *types.Signature:
+			return true
+		}
+	}
+	return false
+}
+
+// libraryMainPath is the package path of the synthetic main package of the library mode.
+const libraryMainPath = "findnil.library.main"
+
+// addLibraryMain adds a synthetic main package which calls exported functions
+// and methods of the analyzed packages so that they become roots of the pointer analysis.
+// Parameters of them may be nil according to policy.
+//
+// Functions and methods which cannot be called from other packages,
+// such as generic functions or functions which have parameters of unexported types, are not roots.
+func (prog *Program) addLibraryMain(policy ParamPolicy) error {
+	pkgs := make(map[string]*types.Package)
+	packages.Visit(prog.Nilless.Pkgs, nil, func(pkg *packages.Package) {
+		pkgs[pkg.Types.Path()] = pkg.Types
+	})
+
+	lib := &libraryMain{
+		aliases: make(map[*types.Package]string),
+	}
+	var roots []*types.Func
+	for _, pkg := range prog.Nilless.Pkgs {
+		if pkg.Types.Name() == "main" {
+			continue
+		}
+		for _, fn := range exportedFuncs(pkg.Types) {
+			if lib.addCall(fn) {
+				roots = append(roots, fn)
+			}
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintln(&src, "package main")
+	// imports are written in the order of their paths
+	// so that the source of the main package is the same in every run
+	imports := make([]*types.Package, 0, len(lib.aliases))
+	for pkg := range lib.aliases {
+		imports = append(imports, pkg)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path() < imports[j].Path()
+	})
+	for _, pkg := range imports {
+		fmt.Fprintf(&src, "import %s %q\n", lib.aliases[pkg], pkg.Path())
+	}
+	fmt.Fprintln(&src, "func main() {")
+	src.Write(lib.body.Bytes())
+	fmt.Fprintln(&src, "}")
+
+	file, err := parser.ParseFile(prog.Fset, "findnil_library_main.go", src.Bytes(), 0)
+	if err != nil {
+		return fmt.Errorf("library mode: %w", err)
+	}
+
+	files := []*ast.File{file}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	config := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if pkg := pkgs[path]; pkg != nil {
+				return pkg, nil
+			}
+			return nil, fmt.Errorf("cannot find package %s", path)
+		}),
+	}
+	tpkg, err := config.Check(libraryMainPath, prog.Fset, files, info)
+	if err != nil {
+		return fmt.Errorf("library mode: %w", err)
+	}
+
+	ssapkg := prog.SSA.CreatePackage(tpkg, files, info, false)
+	ssapkg.Build()
+	prog.Mains = append(prog.Mains, ssapkg)
+
+	prog.ParamPolicy = policy
+	prog.Roots = make(map[*ssa.Function]bool, len(roots))
+	for _, fn := range roots {
+		if f := prog.SSA.FuncValue(fn); f != nil {
+			prog.Roots[f] = true
+		}
+	}
+
+	return nil
+}
+
+// exportedFuncs returns exported functions and methods of exported types in pkg.
+func exportedFuncs(pkg *types.Package) []*types.Func {
+	var funcs []*types.Func
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+
+		switch obj := obj.(type) {
+		case *types.Func:
+			funcs = append(funcs, obj)
+		case *types.TypeName:
+			named, _ := obj.Type().(*types.Named)
+			if named == nil || types.IsInterface(named) {
+				continue
+			}
+			for i := 0; i < named.NumMethods(); i++ {
+				if m := named.Method(i); m.Exported() {
+					funcs = append(funcs, m)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(funcs, func(i, j int) bool {
+		return funcs[i].Pos() < funcs[j].Pos()
+	})
+
+	return funcs
+}
+
+type libraryMain struct {
+	aliases map[*types.Package]string
+	body    bytes.Buffer
+}
+
+func (lib *libraryMain) qualifier(pkg *types.Package) string {
+	alias, ok := lib.aliases[pkg]
+	if !ok {
+		alias = fmt.Sprintf("p%d", len(lib.aliases))
+		lib.aliases[pkg] = alias
+	}
+	return alias
+}
+
+// addCall adds a call of fn to the body of the main function.
+// It reports whether fn can be called.
+func (lib *libraryMain) addCall(fn *types.Func) bool {
+	sig, _ := fn.Type().(*types.Signature)
+	if sig == nil || sig.TypeParams().Len() != 0 {
+		return false
+	}
+
+	args := make([]string, sig.Params().Len())
+	for i := range args {
+		typ := sig.Params().At(i).Type()
+		if sig.Variadic() && i == len(args)-1 {
+			typ = typ.(*types.Slice).Elem()
+		}
+		if !nameable(typ) {
+			return false
+		}
+		// zero values of parameters never make them nil;
+		// whether they are nil is decided by ParamPolicy
+		args[i] = fmt.Sprintf("*new(%s)", types.TypeString(typ, lib.qualifier))
+	}
+
+	var recv string
+	if r := sig.Recv(); r != nil {
+		typ := r.Type()
+		if ptr, _ := typ.(*types.Pointer); ptr != nil {
+			typ = ptr.Elem()
+		}
+		named, _ := typ.(*types.Named)
+		if named == nil || named.TypeParams().Len() != 0 {
+			return false
+		}
+		recv = fmt.Sprintf("new(%s).", types.TypeString(named, lib.qualifier))
+	} else {
+		recv = lib.qualifier(fn.Pkg()) + "."
+	}
+
+	fmt.Fprintf(&lib.body, "\t%s%s(", recv, fn.Name())
+	for i, arg := range args {
+		if i != 0 {
+			fmt.Fprint(&lib.body, ", ")
+		}
+		fmt.Fprint(&lib.body, arg)
+	}
+	fmt.Fprintln(&lib.body, ")")
+
+	return true
+}
+
+// nameable reports whether typ can be written in other packages.
+func nameable(typ types.Type) bool {
+	switch typ := typ.(type) {
+	case *types.Basic:
+		return typ.Kind() != types.Invalid && typ.Info()&types.IsUntyped == 0
+	case *types.Named:
+		obj := typ.Obj()
+		if obj.Pkg() != nil && !obj.Exported() {
+			return false
+		}
+		if typ.TypeParams().Len() != typ.TypeArgs().Len() {
+			return false
+		}
+		for i := 0; i < typ.TypeArgs().Len(); i++ {
+			if !nameable(typ.TypeArgs().At(i)) {
+				return false
+			}
+		}
+		return true
+	case *types.Pointer:
+		return nameable(typ.Elem())
+	case *types.Slice:
+		return nameable(typ.Elem())
+	case *types.Array:
+		return nameable(typ.Elem())
+	case *types.Chan:
+		return nameable(typ.Elem())
+	case *types.Map:
+		return nameable(typ.Key()) && nameable(typ.Elem())
+	case *types.Signature:
+		return typ.TypeParams().Len() == 0 &&
+			nameable(typ.Params()) && nameable(typ.Results())
+	case *types.Tuple:
+		for i := 0; i < typ.Len(); i++ {
+			if !nameable(typ.At(i).Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Struct:
+		for i := 0; i < typ.NumFields(); i++ {
+			f := typ.Field(i)
+			if !f.Exported() || !nameable(f.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		for i := 0; i < typ.NumMethods(); i++ {
+			m := typ.Method(i)
+			if !m.Exported() || !nameable(m.Type()) {
+				return false
+			}
+		}
+		for i := 0; i < typ.NumEmbeddeds(); i++ {
+			if !nameable(typ.EmbeddedType(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// isNilParam reports whether p is a parameter of a root function
+// which may be nil according to the policy of the library mode.
+func (prog *Program) isNilParam(p *ssa.Parameter) bool {
+	fn := p.Parent()
+	if !prog.Roots[fn] || !prog.ParamPolicy.allows(p.Type()) {
+		return false
+	}
+
+	// receivers are not parameters
+	if fn.Signature.Recv() != nil && len(fn.Params) != 0 && fn.Params[0] == p {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,649 @@
+package findnil
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"math"
+	"sort"
+
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// nilFlow is a step of a flow of a nil value.
+// The step which has no previous step is the origin of the nil value.
+type nilFlow struct {
+	prev *nilFlow
+	pos  token.Pos
+	msg  string
+	// conf is the confidence of the step.
+	// Zero value means that the step does not lower the confidence of the flow.
+	conf Confidence
+	// value is the SSA value or instruction of the step.
+	value ssaNode
+	// note describes how the step is derived such as a kind of a call.
+	note string
+	// detail is a step which is shown only by -explain.
+	detail bool
+}
+
+// ssaNode is an ssa.Value or an ssa.Instruction.
+type ssaNode interface {
+	String() string
+	Parent() *ssa.Function
+}
+
+func (f *nilFlow) origin() *nilFlow {
+	for f.prev != nil {
+		f = f.prev
+	}
+	return f
+}
+
+// confidence returns the lowest confidence in the steps.
+func (f *nilFlow) confidence() Confidence {
+	conf := ConfidenceHigh
+	for ; f != nil; f = f.prev {
+		if f.conf != 0 && f.conf < conf {
+			conf = f.conf
+		}
+	}
+	return conf
+}
+
+// steps returns the steps of the flow in order from the origin.
+func (f *nilFlow) steps() []*nilFlow {
+	var steps []*nilFlow
+	for ; f != nil; f = f.prev {
+		steps = append(steps, f)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
+
+// ssa returns the SSA form of the value of the step with the function which has it.
+func (f *nilFlow) ssa() string {
+	if f.value == nil {
+		return ""
+	}
+
+	str := f.value.String()
+	if _, ok := f.value.(ssa.Instruction); ok {
+		if v, ok := f.value.(ssa.Value); ok {
+			str = v.Name() + " = " + str
+		}
+	}
+	if fn := f.value.Parent(); fn != nil {
+		str += " in " + fn.String()
+	}
+
+	return str
+}
+
+// position returns the position of the step.
+// Implicit instructions such as stores of parameters to their variables have no positions,
+// so the positions of the stored values or the functions are used instead.
+func (f *nilFlow) position() token.Pos {
+	if f.pos.IsValid() || f.value == nil {
+		return f.pos
+	}
+
+	if store, _ := f.value.(*ssa.Store); store != nil && store.Val.Pos().IsValid() {
+		return store.Val.Pos()
+	}
+
+	if fn := f.value.Parent(); fn != nil {
+		return fn.Pos()
+	}
+
+	return token.NoPos
+}
+
+// nilMemo memoizes results of isNil.
+type nilMemo struct {
+	flows map[ssa.Value]*nilFlow
+	// depths holds the depths of the values which are being computed
+	depths map[ssa.Value]int
+	// low is the lowest depth of the values being computed
+	// which the current computation has assumed to be not nil
+	low int
+}
+
+func newNilMemo() *nilMemo {
+	return &nilMemo{
+		flows:  make(map[ssa.Value]*nilFlow),
+		depths: make(map[ssa.Value]int),
+		low:    math.MaxInt,
+	}
+}
+
+// isNil reports whether v may be nil.
+// It returns the flow of the nil value or nil if v is not nil.
+//
+// A value which is being computed is assumed to be not nil,
+// so that cycles of values such as recursive calls terminate.
+// A nil value is found regardless of the assumption, so flows are always memoized.
+// The result that v is not nil is memoized only when it does not rely on
+// the assumption about a value which is still being computed below v in the stack,
+// because the value may turn out to be nil later.
+func isNil(prog *Program, memo *nilMemo, v ssa.Value) *nilFlow {
+	if flow, ok := memo.flows[v]; ok {
+		return flow
+	}
+	if depth, ok := memo.depths[v]; ok {
+		if depth < memo.low {
+			memo.low = depth
+		}
+		return nil
+	}
+
+	depth := len(memo.depths)
+	memo.depths[v] = depth
+	low := memo.low
+	memo.low = math.MaxInt
+
+	flow := computeNil(prog, memo, v)
+
+	delete(memo.depths, v)
+	if flow != nil || memo.low >= depth {
+		memo.flows[v] = flow
+	}
+	// assumptions about v itself have been resolved
+	if memo.low < low && memo.low < depth {
+		low = memo.low
+	}
+	memo.low = low
+
+	return flow
+}
+
+func computeNil(prog *Program, memo *nilMemo, v ssa.Value) *nilFlow {
+	if flow := isNilGlobal(prog, v); flow != nil {
+		return flow
+	}
+
+	for _, ref := range refs(v) {
+		ref, _ := ref.(*ssa.DebugRef)
+		if ref == nil {
+			continue
+		}
+		if site := prog.Nilless.NilSite(ref.Object(), ref.Pos()); site != nil {
+			return &nilFlow{pos: ref.Pos(), msg: "nil literal", value: v, note: string(site.Kind)}
+		}
+	}
+
+	switch v := v.(type) {
+	case *ssa.Parameter:
+		if prog.isNilParam(v) {
+			msg := fmt.Sprintf("parameter %s of exported %s may be nil", v.Name(), v.Parent().Name())
+			return &nilFlow{pos: v.Pos(), msg: msg, conf: ConfidenceMedium, value: v}
+		}
+		return isNilArg(prog, memo, v)
+	case *ssa.UnOp:
+		if v.Op == token.MUL {
+			return isNilLoad(prog, memo, v)
+		}
+	case *ssa.Phi:
+		for _, e := range v.Edges {
+			if flow := isNil(prog, memo, e); flow != nil {
+				return flow
+			}
+		}
+	case *ssa.ChangeType:
+		return isNil(prog, memo, v.X)
+	case *ssa.Call:
+		// nil values in generic functions are results of functions declared by nilless
+		if callee := v.Call.StaticCallee(); callee != nil {
+			if site := prog.Nilless.NilSite(callee.Object(), v.Pos()); site != nil {
+				return &nilFlow{pos: v.Pos(), msg: "nil literal", value: v, note: string(site.Kind)}
+			}
+		}
+		return isNilResult(prog, memo, v, 0)
+	case *ssa.Extract:
+		if call, _ := v.Tuple.(*ssa.Call); call != nil {
+			return isNilResult(prog, memo, call, v.Index)
+		}
+	}
+
+	return nil
+}
+
+// isNilLoad reports whether a nil value may be loaded by load.
+func isNilLoad(prog *Program, memo *nilMemo, load *ssa.UnOp) *nilFlow {
+	var flow *nilFlow
+	if addr, _ := load.X.(*ssa.FieldAddr); addr != nil {
+		flow = isNilField(prog, memo, addr)
+	} else {
+		flow = isNilStored(prog, memo, load)
+	}
+
+	if flow == nil || isResultVar(load.X) {
+		return flow
+	}
+	return &nilFlow{prev: flow, pos: load.Pos(), msg: "loaded", value: load, detail: true}
+}
+
+// isNilStored reports whether a nil value may be stored to the address which load loads from.
+// Loads of local variables consider only the stores which reach the load.
+func isNilStored(prog *Program, memo *nilMemo, load *ssa.UnOp) *nilFlow {
+
+	stores, ok := prog.reachingStores(load)
+	if !ok {
+		for _, ref := range refs(load.X) {
+			if store, _ := ref.(*ssa.Store); store != nil && store.Addr == load.X {
+				stores = append(stores, store)
+			}
+		}
+	}
+
+	for _, store := range stores {
+		if flow := isNil(prog, memo, store.Val); flow != nil {
+			// the value of a return statement is not stored by the source
+			if isResultVar(load.X) {
+				return flow
+			}
+			return &nilFlow{prev: flow, pos: store.Pos(), msg: "stored", value: store}
+		}
+	}
+
+	// variables which are not tracked may be stored through other pointers
+	if !ok {
+		return isNilAliased(prog, memo, load)
+	}
+
+	return nil
+}
+
+// isResultVar reports whether addr is the implicit local variable of an unnamed result,
+// which holds the value of a return statement until the deferred calls are run.
+// Its stores and loads are not steps of flows.
+func isResultVar(addr ssa.Value) bool {
+	alloc, _ := addr.(*ssa.Alloc)
+	if alloc == nil || alloc.Comment != "" {
+		return false
+	}
+
+	for _, ref := range refs(alloc) {
+		switch ref := ref.(type) {
+		case *ssa.Store:
+		case *ssa.UnOp:
+			for _, use := range refs(ref) {
+				if _, ok := use.(*ssa.Return); !ok {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isNilField reports whether a nil value may be loaded from the field of addr.
+// When the struct is a local variable or a composite literal which does not escape,
+// only the stores to the field of the struct are considered.
+// Otherwise fields are not distinguished by the structs which they belong to:
+// a field may be nil when any store to the field in the program may store nil,
+// or when the field is never set.
+func isNilField(prog *Program, memo *nilMemo, addr *ssa.FieldAddr) *nilFlow {
+	field := fieldOf(addr)
+	if field == nil {
+		return nil
+	}
+
+	stores := prog.fieldStores()[field]
+	if objs := localObjects(prog, addr.X); objs != nil {
+		var local []*ssa.Store
+		for _, store := range stores {
+			if objs[store.Addr.(*ssa.FieldAddr).X] {
+				local = append(local, store)
+			}
+		}
+		if len(local) != 0 {
+			stores = local
+		}
+	}
+	if len(stores) == 0 {
+		if !canBeNil(field.Type()) {
+			return nil
+		}
+		msg := fmt.Sprintf("field %s is never set", field.Name())
+		return &nilFlow{pos: field.Pos(), msg: msg, conf: ConfidenceMedium, value: addr}
+	}
+
+	for _, store := range stores {
+		if flow := isNil(prog, memo, store.Val); flow != nil {
+			msg := fmt.Sprintf("stored to field %s", field.Name())
+			return &nilFlow{prev: flow, pos: store.Pos(), msg: msg, value: store}
+		}
+	}
+
+	return nil
+}
+
+// fieldStores returns the stores to fields of structs in the source functions of the program.
+func (prog *Program) fieldStores() map[*types.Var][]*ssa.Store {
+	if prog.fields != nil {
+		return prog.fields
+	}
+
+	prog.fields = make(map[*types.Var][]*ssa.Store)
+	for _, pkg := range prog.Packages {
+		funcs := prog.SrcFuncs[pkg]
+		// package level variables are initialized in the init function
+		if init := pkg.Func("init"); init != nil {
+			funcs = append([]*ssa.Function{init}, funcs...)
+		}
+		for _, fn := range funcs {
+			for _, b := range fn.Blocks {
+				for _, instr := range b.Instrs {
+					store, _ := instr.(*ssa.Store)
+					if store == nil {
+						continue
+					}
+					addr, _ := store.Addr.(*ssa.FieldAddr)
+					if addr == nil {
+						continue
+					}
+					if field := fieldOf(addr); field != nil {
+						prog.fields[field] = append(prog.fields[field], store)
+					}
+				}
+			}
+		}
+	}
+
+	return prog.fields
+}
+
+// localObjects returns the allocations which the struct pointer v may point to.
+// It returns nil unless all of them are allocated in the function of v
+// and their fields are accessed only directly.
+func localObjects(prog *Program, v ssa.Value) map[ssa.Value]bool {
+	var allocs []ssa.Value
+	switch v := v.(type) {
+	case *ssa.Alloc:
+		allocs = append(allocs, v)
+	case *ssa.UnOp:
+		if v.Op != token.MUL {
+			return nil
+		}
+		stores, ok := prog.reachingStores(v)
+		if !ok || len(stores) == 0 {
+			return nil
+		}
+		for _, store := range stores {
+			alloc, _ := store.Val.(*ssa.Alloc)
+			if alloc == nil {
+				return nil
+			}
+			allocs = append(allocs, alloc)
+		}
+	default:
+		return nil
+	}
+
+	objs := make(map[ssa.Value]bool, len(allocs))
+	for _, alloc := range allocs {
+		if !accessedDirectly(alloc, true) {
+			return nil
+		}
+		objs[alloc] = true
+
+		// fields of a struct variable are also set by copies of composite literals
+		for _, ref := range refs(alloc) {
+			if store, _ := ref.(*ssa.Store); store != nil && store.Addr == alloc {
+				objs[copiedFrom(store)] = true
+			}
+		}
+	}
+
+	return objs
+}
+
+// copiedFrom returns the local struct which store copies,
+// or nil if the stored value is not loaded from a local struct whose fields are accessed directly.
+func copiedFrom(store *ssa.Store) *ssa.Alloc {
+	load, _ := store.Val.(*ssa.UnOp)
+	if load == nil || load.Op != token.MUL {
+		return nil
+	}
+	alloc, _ := load.X.(*ssa.Alloc)
+	if alloc == nil || alloc.Heap || !accessedDirectly(alloc, false) {
+		return nil
+	}
+	return alloc
+}
+
+// accessedDirectly reports whether the struct pointer v is used only to access its fields.
+// The pointer may be stored into local variables when store is true.
+func accessedDirectly(v ssa.Value, store bool) bool {
+	for _, ref := range refs(v) {
+		switch ref := ref.(type) {
+		case *ssa.FieldAddr, *ssa.DebugRef:
+		case *ssa.UnOp:
+			// copy of the struct
+			if ref.Op != token.MUL {
+				return false
+			}
+		case *ssa.BinOp:
+			if op, _ := nilcheck.Comparison(ref); op == token.ILLEGAL {
+				return false
+			}
+		case *ssa.Store:
+			// the struct is set by a copy of another struct
+			if ref.Addr == v {
+				if copiedFrom(ref) == nil {
+					return false
+				}
+				continue
+			}
+			local, _ := ref.Addr.(*ssa.Alloc)
+			if !store || ref.Val != v || local == nil || !nilcheck.IsTrackable(local) {
+				return false
+			}
+			for _, load := range refs(local) {
+				if load, _ := load.(*ssa.UnOp); load != nil && !accessedDirectly(load, false) {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fieldOf returns the field which addr points to.
+func fieldOf(addr *ssa.FieldAddr) *types.Var {
+	ptr, _ := addr.X.Type().Underlying().(*types.Pointer)
+	if ptr == nil {
+		return nil
+	}
+	st, _ := ptr.Elem().Underlying().(*types.Struct)
+	if st == nil {
+		return nil
+	}
+	return st.Field(addr.Field)
+}
+
+func canBeNil(typ types.Type) bool {
+	switch typ.Underlying().(type) {
+	case *types.Pointer, *types.Interface, *types.Map, *types.Slice,
+		*types.Chan, *types.Signature:
+		return true
+	}
+	return false
+}
+
+// isNilArg reports whether a nil value may be passed to p by callers in the call graph.
+func isNilArg(prog *Program, memo *nilMemo, p *ssa.Parameter) *nilFlow {
+	if prog.CallGraph == nil {
+		return nil
+	}
+
+	fn := p.Parent()
+	node := prog.CallGraph.Nodes[fn]
+	if node == nil {
+		return nil
+	}
+
+	idx := -1
+	for i := range fn.Params {
+		if fn.Params[i] == p {
+			idx = i
+			break
+		}
+	}
+
+	for _, edge := range sortedEdges(prog, node.In) {
+		if edge.Site == nil {
+			continue
+		}
+
+		common := edge.Site.Common()
+		i := idx
+		// the receiver of an interface method call is not an argument
+		if common.IsInvoke() {
+			i--
+		}
+		if i < 0 || i >= len(common.Args) {
+			continue
+		}
+
+		if flow := isNil(prog, memo, common.Args[i]); flow != nil {
+			msg := fmt.Sprintf("passed to parameter %s of %s", p.Name(), fn.Name())
+			return &nilFlow{
+				prev:  flow,
+				pos:   edge.Site.Pos(),
+				msg:   msg,
+				conf:  callConfidence(common),
+				value: edge.Site,
+				note:  callNote(common),
+			}
+		}
+	}
+
+	return nil
+}
+
+// isNilResult reports whether the i-th result of call may be nil.
+// Callees are taken from the call graph, or the static callee if there is no call graph.
+func isNilResult(prog *Program, memo *nilMemo, call *ssa.Call, i int) *nilFlow {
+	for _, callee := range callees(prog, call) {
+		for _, b := range callee.Blocks {
+			ret, _ := b.Instrs[len(b.Instrs)-1].(*ssa.Return)
+			if ret == nil || i >= len(ret.Results) {
+				continue
+			}
+
+			if flow := isNil(prog, memo, ret.Results[i]); flow != nil {
+				flow = &nilFlow{prev: flow, pos: ret.Pos(), msg: "returned", value: ret, detail: true}
+				msg := fmt.Sprintf("returned from %s", callee.Name())
+				return &nilFlow{
+					prev:  flow,
+					pos:   call.Pos(),
+					msg:   msg,
+					conf:  callConfidence(&call.Call),
+					value: call,
+					note:  callNote(&call.Call),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// callees returns the functions which may be called by call.
+func callees(prog *Program, call ssa.CallInstruction) []*ssa.Function {
+	if prog.CallGraph != nil {
+		if node := prog.CallGraph.Nodes[call.Parent()]; node != nil {
+			var fns []*ssa.Function
+			for _, edge := range node.Out {
+				if edge.Site == call {
+					fns = append(fns, edge.Callee.Func)
+				}
+			}
+			sort.Slice(fns, func(i, j int) bool {
+				return fns[i].String() < fns[j].String()
+			})
+			return fns
+		}
+	}
+
+	if callee := call.Common().StaticCallee(); callee != nil {
+		return []*ssa.Function{callee}
+	}
+
+	return nil
+}
+
+// sortedEdges returns edges sorted by the positions of their call sites and their callers,
+// because the order of edges depends on the pointer analysis.
+func sortedEdges(prog *Program, edges []*callgraph.Edge) []*callgraph.Edge {
+	sorted := make([]*callgraph.Edge, len(edges))
+	copy(sorted, edges)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ei, ej := sorted[i], sorted[j]
+		if ei.Site != nil && ej.Site != nil {
+			pi, pj := prog.Fset.Position(ei.Site.Pos()), prog.Fset.Position(ej.Site.Pos())
+			if pi != pj {
+				return positionLess(pi, pj)
+			}
+		}
+		return ei.Caller.Func.String() < ej.Caller.Func.String()
+	})
+	return sorted
+}
+
+// callNote describes the call and how its callees are resolved.
+func callNote(common *ssa.CallCommon) string {
+	if common.StaticCallee() != nil {
+		return common.Description()
+	}
+	return common.Description() + " resolved by the pointer analysis"
+}
+
+// callConfidence returns the confidence of a flow through the call.
+// Callees of dynamic calls are over-approximated by the pointer analysis.
+func callConfidence(common *ssa.CallCommon) Confidence {
+	if common.StaticCallee() != nil {
+		return ConfidenceMedium
+	}
+	return ConfidenceLow
+}
+
+func isNilGlobal(prog *Program, v ssa.Value) *nilFlow {
+	switch v := v.(type) {
+	case *ssa.UnOp:
+		flow := isNilGlobal(prog, v.X)
+		if g, _ := v.X.(*ssa.Global); flow != nil && g != nil && v.Op == token.MUL {
+			msg := fmt.Sprintf("loaded from global variable %s", g.Name())
+			flow = &nilFlow{prev: flow, pos: v.Pos(), msg: msg, value: v, detail: true}
+		}
+		return flow
+	case *ssa.Global:
+		for _, init := range prog.TypesInfo[v.Pkg].InitOrder {
+			if len(init.Lhs) != 1 || v.Object() != init.Lhs[0] {
+				continue
+			}
+
+			id, _ := init.Rhs.(*ast.Ident)
+			if id == nil {
+				continue
+			}
+			if site := prog.Nilless.NilSite(prog.TypesInfo[v.Pkg].Uses[id], id.Pos()); site != nil {
+				msg := fmt.Sprintf("global variable %s is initialized with nil", v.Name())
+				return &nilFlow{pos: v.Pos(), msg: msg, value: v, note: string(site.Kind)}
+			}
+		}
+	}
+
+	return nil
+}
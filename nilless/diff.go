@@ -0,0 +1,149 @@
+package nilless
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes in a hunk.
+const diffContext = 3
+
+// Diff writes the differences between the original sources and the rewritten program
+// to w in the unified format.
+// The file names are relative to dir and the declarations files are shown as new files.
+func (r *Result) Diff(w io.Writer, dir string) error {
+	paths := make([]string, 0, len(r.Overlay))
+	for path := range r.Overlay {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		name := path
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+
+		oldName := "a/" + name
+		orig, ok := r.origs[path]
+		if !ok {
+			oldName = "/dev/null"
+		}
+
+		if err := unifiedDiff(w, oldName, "b/"+name, orig, r.Overlay[path]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffLine is a line of a unified diff.
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+	// indexes of the line in the old and the new source,
+	// or the indexes of the next lines if the line is not in them
+	old, new int
+}
+
+// unifiedDiff writes the differences between src1 and src2 to w in the unified format.
+// It writes nothing if they are same.
+func unifiedDiff(w io.Writer, name1, name2 string, src1, src2 []byte) error {
+	a, b := splitLines(src1), splitLines(src2)
+
+	var lines []diffLine
+	i, j := 0, 0
+	for _, pair := range append(lcs(a, b), [2]int{len(a), len(b)}) {
+		for ; i < pair[0]; i++ {
+			lines = append(lines, diffLine{op: '-', text: a[i], old: i, new: j})
+		}
+		for ; j < pair[1]; j++ {
+			lines = append(lines, diffLine{op: '+', text: b[j], old: i, new: j})
+		}
+		if i < len(a) {
+			lines = append(lines, diffLine{op: ' ', text: a[i], old: i, new: j})
+			i, j = i+1, j+1
+		}
+	}
+
+	var buf bytes.Buffer
+	for start := 0; start < len(lines); {
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// a hunk continues while changes are separated by less than 2*diffContext lines
+		end, unchanged := start, 0
+		for k := start; k < len(lines) && unchanged <= 2*diffContext; k++ {
+			if lines[k].op == ' ' {
+				unchanged++
+				continue
+			}
+			end, unchanged = k+1, 0
+		}
+
+		from, to := start-diffContext, end+diffContext
+		if from < 0 {
+			from = 0
+		}
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name1, name2)
+		}
+		writeHunk(&buf, lines[from:to])
+		start = to
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeHunk(buf *bytes.Buffer, lines []diffLine) {
+	var len1, len2 int
+	for _, l := range lines {
+		if l.op != '+' {
+			len1++
+		}
+		if l.op != '-' {
+			len2++
+		}
+	}
+
+	// an empty range starts at the line before it
+	start1, start2 := lines[0].old, lines[0].new
+	if len1 != 0 {
+		start1++
+	}
+	if len2 != 0 {
+		start2++
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", start1, len1, start2, len2)
+
+	for _, l := range lines {
+		buf.WriteByte(l.op)
+		buf.WriteString(l.text)
+		if !strings.HasSuffix(l.text, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits src into lines which end with a newline except the last one.
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
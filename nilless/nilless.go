@@ -8,63 +8,110 @@ import (
 	"go/parser"
 	"go/token"
 	"go/types"
-	"io"
+	"go/version"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"go.uber.org/multierr"
-	"golang.org/x/mod/modfile"
 	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
-	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/types/typeutil"
 	"golang.org/x/tools/imports"
 )
 
 type Result struct {
-	tmpdir string
-	Pkgs   []*packages.Package
-	Fset   *token.FileSet
-	IsNil  map[string]bool
+	Pkgs []*packages.Package
+	Fset *token.FileSet
+	// IsNil holds the names of the declarations which nil values are replaced with.
+	//
+	// Deprecated: Use SitesOf or NilSite, which distinguish declarations of different packages.
+	IsNil map[string]bool
+	// IsZero holds the names of the declarations which zero values are replaced with.
+	//
+	// Deprecated: Use SitesOf, which distinguishes declarations of different packages.
 	IsZero map[string]bool
+	// Sites holds the replaced values in Pkgs and their dependencies.
+	Sites []*NilSite
+	// Overlay holds the contents of the rewritten files and the declarations files by their paths.
+	Overlay map[string][]byte
+	sites   map[types.Object][]*NilSite
+	// names holds the names of the source files which are shown to users.
+	names map[string]string
+	// files maps the positions in the rewritten files to the original files.
+	files map[string]*fileMap
+	// origs holds the original sources of the rewritten files.
+	origs map[string][]byte
 }
 
+// Base returns the name of the source file path which consists of
+// the path of the package and the base name of the file.
 func (r *Result) Base(path string) string {
-	return strings.TrimPrefix(path, filepath.Clean(r.tmpdir)+"/")
+	if name, ok := r.names[path]; ok {
+		return name
+	}
+	return path
 }
 
-func Load(cfg *packages.Config, patterns ...string) (_ *Result, rerr error) {
-	pkgs, err := packages.Load(cfg, patterns...)
-	if err != nil {
-		return nil, err
+// Position returns the position in the original source of pos in the rewritten program.
+// Positions in the declarations files which are added by nilless are returned as they are.
+func (r *Result) Position(pos token.Pos) token.Position {
+	if r.Fset == nil || !pos.IsValid() {
+		return token.Position{}
 	}
 
-	dir, err := os.MkdirTemp("", "nilless-*")
-	if err != nil {
-		return nil, err
+	file := r.Fset.File(pos)
+	if file == nil {
+		return token.Position{}
+	}
+
+	m := r.files[file.Name()]
+	if m == nil {
+		return r.Fset.Position(pos)
 	}
-	// for debug
-	//fmt.Println("work in", dir)
+
+	return m.orig.Position(m.orig.Pos(m.offset(file.Offset(pos))))
+}
+
+// Load loads the packages and reloads them with the source files
+// whose nil values are replaced with variables.
+// The rewritten files are passed through cfg.Overlay,
+// so that the packages are reloaded in place with the original file paths.
+func Load(cfg *packages.Config, patterns ...string) (_ *Result, rerr error) {
 	defer func() {
-		rerr = multierr.Append(rerr, os.RemoveAll(dir))
 		if rerr != nil {
 			rerr = fmt.Errorf("nilless.Load: %w", rerr)
 		}
 	}()
 
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &replacer{
-		cfg:    cfg,
-		pkgs:   pkgs,
-		hasher: typeutil.MakeHasher(),
-		dir:    dir,
+		cfg:       cfg,
+		pkgs:      pkgs,
+		nilDecls:  make(map[*packages.Package]*typeutil.Map),
+		nilFuncs:  make(map[*packages.Package]*typeutil.Map),
+		zeroDecls: make(map[*packages.Package]*typeutil.Map),
+		anyDecls:  make(map[*packages.Package]*nilDecl),
+		imports:   make(map[*packages.Package]*fileImports),
+		overlay:   make(map[string][]byte),
+		declNames: make(map[string]string),
+		declared:  make(map[string]map[string]bool),
+		sites:     make(map[string][]*pendingSite),
 		result: &Result{
-			tmpdir: dir,
 			IsNil:  make(map[string]bool),
 			IsZero: make(map[string]bool),
+			sites:  make(map[types.Object][]*NilSite),
+			names:  make(map[string]string),
+			files:  make(map[string]*fileMap),
+			origs:  make(map[string][]byte),
 		},
+		rewritten: make(map[string]bool),
 	}
 
 	if len(r.pkgs) == 0 {
@@ -82,38 +129,20 @@ func Load(cfg *packages.Config, patterns ...string) (_ *Result, rerr error) {
 		return nil, pkgerr
 	}
 
-	for i := range r.pkgs {
+	for _, i := range rewriteOrder(r.pkgs) {
 		r.idx = i
 		if err := r.do(); err != nil {
 			return nil, err
 		}
 	}
 
-	modfilepath := "go.mod"
-	if r.cfg.Dir != "" {
-		modfilepath = filepath.Join(r.cfg.Dir, modfilepath)
-	}
-	mod, err := os.ReadFile(modfilepath)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("read go.mod: %w", err)
-	}
-	modpath := modfile.ModulePath(mod)
-
 	newCfg := *(r.cfg)
-	if modpath == "" {
-		newCfg.Dir = dir
-	} else {
-		newCfg.Dir = filepath.Join(dir, filepath.FromSlash(modpath))
-		if err := os.MkdirAll(newCfg.Dir, 0700); err != nil {
-			return nil, fmt.Errorf("mkdir %s: %w", newCfg.Dir, err)
-		}
-		if err := os.WriteFile(filepath.Join(newCfg.Dir, "go.mod"), mod, 0o666); err != nil {
-			return nil, fmt.Errorf("copy go.mod: %w", err)
-		}
+	newCfg.Overlay = make(map[string][]byte, len(r.cfg.Overlay)+len(r.overlay))
+	for path, src := range r.cfg.Overlay {
+		newCfg.Overlay[path] = src
 	}
-
-	if err := modtidy(newCfg.Dir); err != nil {
-		return nil, fmt.Errorf("packages.Load: go mod tidy: %w", err)
+	for path, src := range r.overlay {
+		newCfg.Overlay[path] = src
 	}
 
 	newCfg.Fset = token.NewFileSet()
@@ -121,41 +150,132 @@ func Load(cfg *packages.Config, patterns ...string) (_ *Result, rerr error) {
 	if err != nil {
 		return nil, fmt.Errorf("packages.Load: %w", err)
 	}
+	packages.Visit(newPkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			pkgerr = multierr.Append(pkgerr, err)
+		}
+	})
+	if pkgerr != nil {
+		return nil, fmt.Errorf("rewritten packages: %w", pkgerr)
+	}
 	r.result.Pkgs = newPkgs
 	r.result.Fset = newCfg.Fset
+	r.result.Overlay = r.overlay
+
+	for path := range r.rewritten {
+		src, ok := r.cfg.Overlay[path]
+		if !ok {
+			src, err = os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.result.origs[path] = src
+		r.result.files[path] = newFileMap(path, src, r.overlay[path])
+	}
+
+	// the declarations are looked up in each package which has the rewritten files
+	packages.Visit(newPkgs, nil, func(pkg *packages.Package) {
+		for _, file := range pkg.Syntax {
+			for _, pending := range r.sites[pkg.Fset.File(file.Pos()).Name()] {
+				obj := pkg.Types.Scope().Lookup(pending.name)
+				if obj == nil {
+					continue
+				}
+				site := pending.site
+				site.Object = obj
+				r.result.Sites = append(r.result.Sites, &site)
+				r.result.sites[obj] = append(r.result.sites[obj], &site)
+				if site.IsNil() {
+					r.result.IsNil[obj.Name()] = true
+				} else {
+					r.result.IsZero[obj.Name()] = true
+				}
+			}
+		}
+	})
 
 	return r.result, nil
 }
 
-func modtidy(dir string) error {
-	cmd := exec.Command("go", "mod", "tidy")
-	cmd.Dir = dir
-	cmd.Stdout = io.Discard
-	cmd.Stderr = io.Discard
-	return cmd.Run()
+// rewriteOrder returns indexes of pkgs in the order of rewriting.
+// Test variants are rewritten after non-test variants because they only add test files,
+// and generated test main packages are not rewritten because the second load generates them again.
+func rewriteOrder(pkgs []*packages.Package) []int {
+	order := make([]int, 0, len(pkgs))
+	for _, test := range []bool{false, true} {
+		for i, pkg := range pkgs {
+			if strings.HasSuffix(pkg.ID, ".test") {
+				continue
+			}
+			if isTestVariant(pkg) == test {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
+
+// isTestVariant reports whether pkg is a package compiled for a test such as "a [a.test]".
+func isTestVariant(pkg *packages.Package) bool {
+	return strings.HasSuffix(pkg.ID, ".test]")
 }
 
+// nilDecl is a declaration of a nil value.
+// A nil value of a type which refers to type parameters is declared as a generic function
+// because package level variables cannot refer to them.
+// Nil values in generic functions are also declared as functions
+// because loads of variables in instantiations of generic functions cannot be related to the source.
 type nilDecl struct {
-	froms   map[*packages.Package]bool
-	gendecl *ast.GenDecl
+	decl    ast.Decl
 	name    string
+	tparams []*types.TypeParam
+}
+
+// value returns an expression which refers to the nil value.
+func (d *nilDecl) value() ast.Expr {
+	if _, ok := d.decl.(*ast.FuncDecl); ok {
+		return &ast.CallExpr{Fun: instantiate(d.name, d.tparams)}
+	}
+	return ast.NewIdent(d.name)
 }
 
 type zeroDecl struct {
-	froms    map[*packages.Package]bool
 	funcdecl *ast.FuncDecl
 	name     string
+	tparams  []*types.TypeParam
+}
+
+// value returns an expression which refers to the zero value.
+func (d *zeroDecl) value() ast.Expr {
+	return &ast.CallExpr{Fun: instantiate(d.name, d.tparams)}
 }
 
 type replacer struct {
-	cfg       *packages.Config
-	idx       int
-	pkgs      []*packages.Package
-	hasher    typeutil.Hasher
-	nilDecls  typeutil.Map // value is *nilDecl
-	zeroDecls typeutil.Map // value is *zeroDecl
-	dir       string
-	result    *Result
+	cfg  *packages.Config
+	idx  int
+	pkgs []*packages.Package
+	// declarations are made for each package because
+	// type expressions in them depend on the package
+	nilDecls  map[*packages.Package]*typeutil.Map // value is *nilDecl
+	nilFuncs  map[*packages.Package]*typeutil.Map // value is *nilDecl declared as a function
+	zeroDecls map[*packages.Package]*typeutil.Map // value is *zeroDecl
+	// anyDecls holds the generic function which returns the zero value of any type
+	anyDecls map[*packages.Package]*nilDecl
+	// imports of the declarations file of each package
+	imports map[*packages.Package]*fileImports
+	// overlay holds the contents of the rewritten files and the declarations files.
+	overlay map[string][]byte
+	// rewritten holds the paths of the rewritten files.
+	rewritten map[string]bool
+	// sites holds the replaced values for each original file
+	sites  map[string][]*pendingSite
+	result *Result
+	// declNames holds the keys of the types of the declarations for each package path and name.
+	declNames map[string]string
+	// declared holds names of declarations which have been output
+	// for each pair of a directory and a package name.
+	declared map[string]map[string]bool
 }
 
 func (r *replacer) do() error {
@@ -164,10 +284,15 @@ func (r *replacer) do() error {
 
 	var err error
 	for i, file := range r.pkgs[r.idx].Syntax {
-		// TODO(tenntenn): more replacing
-		// - composite literals
-		// - naked returns
-		n := astutil.Apply(file, func(c *astutil.Cursor) bool {
+		// non-test files of a test variant are rewritten by the non-test variant
+		if isTestVariant(r.pkgs[r.idx]) && !r.isTestFile(file) {
+			newFiles[i] = file
+			continue
+		}
+
+		// nodes are replaced after their children are replaced
+		// because Apply traverses children of the original node even if it is replaced
+		n := astutil.Apply(file, nil, func(c *astutil.Cursor) bool {
 			switch n := c.Node().(type) {
 			case *ast.ValueSpec:
 				switch {
@@ -180,10 +305,31 @@ func (r *replacer) do() error {
 				}
 			case *ast.ReturnStmt:
 				err = multierr.Append(err, r.returnStmt(c, n))
+			case *ast.AssignStmt:
+				if len(n.Lhs) == len(n.Rhs) {
+					err = multierr.Append(err, r.assignStmt(c, n))
+				}
+			case *ast.CallExpr:
+				err = multierr.Append(err, r.callExpr(c, n))
+			case *ast.CompositeLit:
+				err = multierr.Append(err, r.compositeLit(c, n))
+			case *ast.FuncDecl:
+				body, err2 := r.namedResults(n.Type, n.Body)
+				err = multierr.Append(err, err2)
+				if body != nil {
+					newDecl := *n
+					newDecl.Body = body
+					c.Replace(&newDecl)
+				}
+			case *ast.FuncLit:
+				body, err2 := r.namedResults(n.Type, n.Body)
+				err = multierr.Append(err, err2)
+				if body != nil {
+					c.Replace(&ast.FuncLit{Type: n.Type, Body: body})
+				}
 			}
 			return true
-
-		}, nil)
+		})
 		f, _ := n.(*ast.File)
 		if f == nil {
 			return fmt.Errorf("unexpected node type: %v", n)
@@ -214,10 +360,13 @@ func (r *replacer) returnStmt(c *astutil.Cursor, ret *ast.ReturnStmt) error {
 			continue
 		}
 		typ := rets.At(i).Type()
-		newVal, err := r.nilValue(typ)
+		newVal, err := r.nilValueAt(typ, val.Pos(), RewriteReturn)
 		if err != nil {
 			return err
 		}
+		if newVal == nil {
+			newVal = val
+		}
 		newRet.Results[i] = newVal
 	}
 
@@ -226,6 +375,323 @@ func (r *replacer) returnStmt(c *astutil.Cursor, ret *ast.ReturnStmt) error {
 	return nil
 }
 
+// namedResults returns the body of a function which begins with assignments of nil values
+// to the named results which can be nil, so that naked returns return the nil values.
+// It returns nil if the function has no such results or no naked returns.
+func (r *replacer) namedResults(typ *ast.FuncType, body *ast.BlockStmt) (*ast.BlockStmt, error) {
+	if body == nil || typ.Results == nil || !hasNakedReturn(body) {
+		return nil, nil
+	}
+
+	assign := &ast.AssignStmt{
+		TokPos: body.Lbrace,
+		Tok:    token.ASSIGN,
+	}
+	for _, field := range typ.Results.List {
+		for _, name := range field.Names {
+			typ := r.pkgs[r.idx].TypesInfo.TypeOf(name)
+			if name.Name == "_" || !canBeNil(typ) {
+				continue
+			}
+
+			val, err := r.nilValueAt(typ, name.End(), RewriteNamedResult)
+			if err != nil {
+				return nil, err
+			}
+			if val == nil {
+				continue
+			}
+			assign.Lhs = append(assign.Lhs, &ast.Ident{NamePos: body.Lbrace, Name: name.Name})
+			assign.Rhs = append(assign.Rhs, val)
+		}
+	}
+
+	if len(assign.Lhs) == 0 {
+		return nil, nil
+	}
+
+	return &ast.BlockStmt{
+		Lbrace: body.Lbrace,
+		List:   append([]ast.Stmt{assign}, body.List...),
+		Rbrace: body.Rbrace,
+	}, nil
+}
+
+// hasNakedReturn reports whether body has a return statement without results.
+// Returns in function literals return from the literals.
+func hasNakedReturn(body *ast.BlockStmt) bool {
+	var found bool
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if len(n.Results) == 0 {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (r *replacer) assignStmt(c *astutil.Cursor, assign *ast.AssignStmt) error {
+	newAssign := &ast.AssignStmt{
+		Lhs:    assign.Lhs,
+		TokPos: assign.TokPos,
+		Tok:    assign.Tok,
+		Rhs:    make([]ast.Expr, len(assign.Rhs)),
+	}
+
+	for i, val := range assign.Rhs {
+		if !r.isNil(val) {
+			newAssign.Rhs[i] = val
+			continue
+		}
+		typ := r.pkgs[r.idx].TypesInfo.TypeOf(assign.Lhs[i])
+		newVal, err := r.nilValueAt(typ, val.Pos(), RewriteAssign)
+		if err != nil {
+			return err
+		}
+		// the type of a value which cannot be written is inferred from the left hand side
+		if newVal == nil && len(assign.Lhs) == len(assign.Rhs) && isPure(assign.Lhs[i]) {
+			newVal = r.anyValueAt(typ, assign.Lhs[i], nil, val.Pos(), RewriteAssign)
+		}
+		if newVal == nil {
+			newVal = val
+		}
+		newAssign.Rhs[i] = newVal
+	}
+
+	c.Replace(newAssign)
+
+	return nil
+}
+
+// callExpr replaces a conversion of nil such as (*T)(nil)
+// or nil passed as arguments of a function call.
+func (r *replacer) callExpr(c *astutil.Cursor, call *ast.CallExpr) error {
+	info := r.pkgs[r.idx].TypesInfo
+	fun := info.Types[call.Fun]
+	switch {
+	case fun.IsType():
+		if len(call.Args) != 1 || !r.isNil(call.Args[0]) {
+			return nil
+		}
+		newVal, err := r.nilValueAt(fun.Type, call.Pos(), RewriteConversion)
+		if err != nil || newVal == nil {
+			return err
+		}
+		// a nil value of the underlying type must be converted
+		if typ := nameableType(r.pkgs[r.idx].Types, fun.Type); !types.Identical(typ, fun.Type) {
+			placeAt(newVal, call.Args[0].Pos())
+			newVal = &ast.CallExpr{
+				Fun:    call.Fun,
+				Lparen: call.Lparen,
+				Args:   []ast.Expr{newVal},
+				Rparen: call.Rparen,
+			}
+		}
+		c.Replace(newVal)
+		return nil
+	case fun.IsBuiltin():
+		return nil
+	}
+
+	sig, _ := fun.Type.Underlying().(*types.Signature)
+	if sig == nil {
+		return nil
+	}
+
+	newCall := &ast.CallExpr{
+		Fun:      call.Fun,
+		Lparen:   call.Lparen,
+		Args:     make([]ast.Expr, len(call.Args)),
+		Ellipsis: call.Ellipsis,
+		Rparen:   call.Rparen,
+	}
+
+	var replaced bool
+	params := sig.Params()
+	for i, arg := range call.Args {
+		newCall.Args[i] = arg
+		if !r.isNil(arg) || params.Len() == 0 {
+			continue
+		}
+
+		var typ types.Type
+		switch {
+		case sig.Variadic() && i >= params.Len()-1 && !call.Ellipsis.IsValid():
+			typ = params.At(params.Len() - 1).Type().(*types.Slice).Elem()
+		case i < params.Len():
+			typ = params.At(i).Type()
+		default:
+			continue
+		}
+
+		if !canBeNil(typ) {
+			continue
+		}
+
+		newVal, err := r.nilValueAt(typ, arg.Pos(), RewriteArgument)
+		if err != nil {
+			return err
+		}
+		if newVal == nil {
+			continue
+		}
+		newCall.Args[i] = newVal
+		replaced = true
+	}
+
+	if replaced {
+		c.Replace(newCall)
+	}
+
+	return nil
+}
+
+// compositeLit replaces nil elements of a composite literal
+// and adds nil values for omitted fields of a struct literal which can be nil.
+func (r *replacer) compositeLit(c *astutil.Cursor, lit *ast.CompositeLit) error {
+	typ := r.pkgs[r.idx].TypesInfo.TypeOf(lit)
+	if typ == nil {
+		return nil
+	}
+	// the type of an element whose type is elided such as &T{} in []*T{{}}
+	if ptr, _ := typ.Underlying().(*types.Pointer); ptr != nil {
+		typ = ptr.Elem()
+	}
+
+	newLit := &ast.CompositeLit{
+		Type:       lit.Type,
+		Lbrace:     lit.Lbrace,
+		Elts:       make([]ast.Expr, len(lit.Elts)),
+		Rbrace:     lit.Rbrace,
+		Incomplete: lit.Incomplete,
+	}
+	copy(newLit.Elts, lit.Elts)
+
+	// replace sets a nil value of typ to *expr if *expr is nil
+	var replaced bool
+	replace := func(expr *ast.Expr, typ types.Type) error {
+		if !r.isNil(*expr) {
+			return nil
+		}
+		val, err := r.nilValueAt(typ, (*expr).Pos(), RewriteElement)
+		if err != nil || val == nil {
+			return err
+		}
+		*expr = val
+		replaced = true
+		return nil
+	}
+
+	switch u := typ.Underlying().(type) {
+	case *types.Struct:
+		set := make(map[string]bool)
+		for i, elt := range newLit.Elts {
+			kv, _ := elt.(*ast.KeyValueExpr)
+			if kv == nil {
+				if err := replace(&newLit.Elts[i], u.Field(i).Type()); err != nil {
+					return err
+				}
+				set[u.Field(i).Name()] = true
+				continue
+			}
+
+			key, _ := kv.Key.(*ast.Ident)
+			if key == nil {
+				continue
+			}
+			set[key.Name] = true
+			field, _ := r.pkgs[r.idx].TypesInfo.ObjectOf(key).(*types.Var)
+			if field == nil {
+				continue
+			}
+			newKV := &ast.KeyValueExpr{Key: kv.Key, Colon: kv.Colon, Value: kv.Value}
+			if err := replace(&newKV.Value, field.Type()); err != nil {
+				return err
+			}
+			newLit.Elts[i] = newKV
+		}
+
+		// positional literals have all the fields
+		if len(lit.Elts) != 0 {
+			if _, ok := lit.Elts[0].(*ast.KeyValueExpr); !ok {
+				break
+			}
+		}
+
+		for i := 0; i < u.NumFields(); i++ {
+			field := u.Field(i)
+			if set[field.Name()] || field.Name() == "_" || !canBeNil(field.Type()) ||
+				(!field.Exported() && field.Pkg() != r.pkgs[r.idx].Types) {
+				continue
+			}
+			val, err := r.nilValueAt(field.Type(), lit.Rbrace, RewriteOmittedField)
+			if err != nil {
+				return err
+			}
+			if val == nil {
+				continue
+			}
+			newLit.Elts = append(newLit.Elts, &ast.KeyValueExpr{
+				Key:   &ast.Ident{NamePos: lit.Rbrace, Name: field.Name()},
+				Colon: lit.Rbrace,
+				Value: val,
+			})
+			replaced = true
+		}
+	case *types.Slice:
+		for i := range newLit.Elts {
+			if err := r.replaceElt(&newLit.Elts[i], u.Elem(), nil, replace); err != nil {
+				return err
+			}
+		}
+	case *types.Array:
+		for i := range newLit.Elts {
+			if err := r.replaceElt(&newLit.Elts[i], u.Elem(), nil, replace); err != nil {
+				return err
+			}
+		}
+	case *types.Map:
+		for i := range newLit.Elts {
+			if err := r.replaceElt(&newLit.Elts[i], u.Elem(), u.Key(), replace); err != nil {
+				return err
+			}
+		}
+	}
+
+	if replaced {
+		c.Replace(newLit)
+	}
+
+	return nil
+}
+
+// replaceElt replaces a nil element of a slice, array or map literal.
+// Keys of slices and arrays are indexes which are never nil.
+func (r *replacer) replaceElt(elt *ast.Expr, elem, key types.Type, replace func(*ast.Expr, types.Type) error) error {
+	kv, _ := (*elt).(*ast.KeyValueExpr)
+	if kv == nil {
+		return replace(elt, elem)
+	}
+
+	newKV := &ast.KeyValueExpr{Key: kv.Key, Colon: kv.Colon, Value: kv.Value}
+	if key != nil {
+		if err := replace(&newKV.Key, key); err != nil {
+			return err
+		}
+	}
+	if err := replace(&newKV.Value, elem); err != nil {
+		return err
+	}
+	*elt = newKV
+
+	return nil
+}
+
 func (r *replacer) funcByPos(pos token.Pos) (sig *types.Signature) {
 	file := r.fileByPos(pos)
 	if file == nil {
@@ -245,6 +711,24 @@ func (r *replacer) funcByPos(pos token.Pos) (sig *types.Signature) {
 	return nil
 }
 
+// isInGeneric reports whether pos is in a generic function or a method of a generic type.
+func (r *replacer) isInGeneric(pos token.Pos) bool {
+	file := r.fileByPos(pos)
+	if file == nil {
+		return false
+	}
+	path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+	for _, n := range path {
+		decl, _ := n.(*ast.FuncDecl)
+		if decl == nil {
+			continue
+		}
+		sig, _ := r.pkgs[r.idx].TypesInfo.TypeOf(decl.Name).(*types.Signature)
+		return sig != nil && (sig.TypeParams().Len() != 0 || sig.RecvTypeParams().Len() != 0)
+	}
+	return false
+}
+
 func (r *replacer) fileByPos(pos token.Pos) *ast.File {
 	for _, f := range r.pkgs[r.idx].Syntax {
 		if f.Pos() <= pos && pos <= f.End() {
@@ -256,8 +740,10 @@ func (r *replacer) fileByPos(pos token.Pos) *ast.File {
 
 func (r *replacer) declAndAssign(c *astutil.Cursor, spec *ast.ValueSpec) error {
 	newSpec := &ast.ValueSpec{
-		Doc:     spec.Doc,
-		Names:   make([]*ast.Ident, len(spec.Names)),
+		Doc:   spec.Doc,
+		Names: make([]*ast.Ident, len(spec.Names)),
+		// the type must be kept because values may be converted into it such as interfaces
+		Type:    spec.Type,
 		Values:  make([]ast.Expr, len(spec.Values)),
 		Comment: spec.Comment,
 	}
@@ -269,10 +755,13 @@ func (r *replacer) declAndAssign(c *astutil.Cursor, spec *ast.ValueSpec) error {
 			continue
 		}
 		typ := r.pkgs[r.idx].TypesInfo.TypeOf(newSpec.Names[i])
-		newVal, err := r.nilValue(typ)
+		newVal, err := r.nilValueAt(typ, val.Pos(), RewriteVarInit)
 		if err != nil {
 			return err
 		}
+		if newVal == nil {
+			newVal = val
+		}
 		newSpec.Values[i] = newVal
 	}
 
@@ -287,12 +776,26 @@ func (r *replacer) isNil(expr ast.Expr) bool {
 	return isNil
 }
 
-func (r *replacer) nilValue(typ types.Type) (ast.Expr, error) {
+// nilValue returns a nil value of typ.
+// The value is a call of a function if asFunc is true or typ refers to type parameters.
+// If typ cannot be written in the declarations file, the value has the underlying type of typ.
+// It returns nil if neither of them can be written.
+func (r *replacer) nilValue(typ types.Type, asFunc bool) (*nilDecl, error) {
+	typ = nameableType(r.pkgs[r.idx].Types, typ)
+	if typ == nil {
+		return nil, nil
+	}
+
+	tparams := typeParams(typ)
+	asFunc = asFunc || len(tparams) != 0
 
-	decl, _ := r.nilDecls.At(typ).(*nilDecl)
+	nilDecls := declsOf(r.nilDecls, r.pkgs[r.idx])
+	if asFunc {
+		nilDecls = declsOf(r.nilFuncs, r.pkgs[r.idx])
+	}
+	decl, _ := nilDecls.At(typ).(*nilDecl)
 	if decl != nil {
-		decl.froms[r.pkgs[r.idx]] = true
-		return ast.NewIdent(decl.name), nil
+		return decl, nil
 	}
 
 	typExpr, err := parser.ParseExpr(r.typeString(typ))
@@ -300,13 +803,26 @@ func (r *replacer) nilValue(typ types.Type) (ast.Expr, error) {
 		return nil, fmt.Errorf("parse type string(%s): %w", typ.String(), err)
 	}
 
-	name := uniqName(fmt.Sprintf("__nil_%p_%d_*", r.pkgs[r.idx], r.hasher.Hash(typ)), func(name string) bool {
-		return r.pkgs[r.idx].Types.Scope().Lookup(name) == nil
-	})
+	form := "var"
+	if asFunc {
+		form = "func"
+	}
+	name := r.declName("__nil", form, typ, tparams)
 
 	decl = &nilDecl{
-		froms: map[*packages.Package]bool{r.pkgs[r.idx]: true},
-		gendecl: &ast.GenDecl{
+		name:    name,
+		tparams: tparams,
+	}
+
+	switch {
+	case asFunc:
+		funcdecl, err := r.funcDecl(name, tparams, typExpr)
+		if err != nil {
+			return nil, err
+		}
+		decl.decl = funcdecl
+	default:
+		decl.decl = &ast.GenDecl{
 			Tok: token.VAR,
 			Specs: []ast.Spec{&ast.ValueSpec{
 				Names: []*ast.Ident{ast.NewIdent(name)},
@@ -317,27 +833,104 @@ func (r *replacer) nilValue(typ types.Type) (ast.Expr, error) {
 						Args: []ast.Expr{typExpr},
 					}}},
 			}},
-		},
-		name: name,
+		}
 	}
 
-	r.nilDecls.Set(typ, decl)
-	r.result.IsNil[name] = true
+	nilDecls.Set(typ, decl)
 
-	return ast.NewIdent(decl.name), nil
+	return decl, nil
 }
 
-func (r *replacer) declsFile() *ast.File {
-	decls := make([]ast.Decl, 0, r.nilDecls.Len()+r.zeroDecls.Len())
+// nilValueAt returns a value of nilValue which is placed at pos of the replaced nil,
+// so that comments around it are kept on the same lines.
+// The replaced nil is recorded as a site of kind.
+func (r *replacer) nilValueAt(typ types.Type, pos token.Pos, kind RewriteKind) (ast.Expr, error) {
+	decl, err := r.nilValue(typ, r.isInGeneric(pos))
+	if err != nil || decl == nil {
+		return nil, err
+	}
+	r.addSite(decl.name, typ, pos, kind)
+	val := decl.value()
+	placeAt(val, pos)
+	return val, nil
+}
 
-	r.nilDecls.Iterate(func(_ types.Type, val interface{}) {
-		if decl, _ := val.(*nilDecl); decl != nil && decl.froms[r.pkgs[r.idx]] {
-			decls = append(decls, decl.gendecl)
+// placeAt sets pos to positions of a nil value or a zero value.
+func placeAt(val ast.Expr, pos token.Pos) {
+	switch val := val.(type) {
+	case *ast.Ident:
+		val.NamePos = pos
+	case *ast.CallExpr:
+		placeAt(val.Fun, pos)
+		val.Lparen, val.Rparen = pos, pos
+	case *ast.IndexExpr:
+		placeAt(val.X, pos)
+		placeAt(val.Index, pos)
+		val.Lbrack, val.Rbrack = pos, pos
+	case *ast.IndexListExpr:
+		placeAt(val.X, pos)
+		for _, index := range val.Indices {
+			placeAt(index, pos)
 		}
+		val.Lbrack, val.Rbrack = pos, pos
+	}
+}
+
+// declName returns the name of a declaration of a value of typ in the current package
+// which is derived from the path of the package, the hash of typ and a counter.
+// The same name is returned for the same type in a package and its test variant,
+// so that the test variant can use the declaration of the package.
+func (r *replacer) declName(prefix, form string, typ types.Type, tparams []*types.TypeParam) string {
+	pkg := r.pkgs[r.idx].Types
+	key := form + " " + types.TypeString(typ, (*types.Package).Path)
+	for _, tparam := range tparams {
+		key += " " + tparam.Obj().Name() + " " + types.TypeString(tparam.Constraint(), (*types.Package).Path)
+	}
+
+	pattern := fmt.Sprintf("%s_%s_%s_*", prefix, hashString(pkg.Path()), hashString(key))
+	name := uniqName(pattern, func(name string) bool {
+		declared, ok := r.declNames[pkg.Path()+"."+name]
+		return (!ok || declared == key) && pkg.Scope().Lookup(name) == nil
 	})
+	r.declNames[pkg.Path()+"."+name] = key
+
+	return name
+}
+
+func (r *replacer) importsOf(pkg *packages.Package) *fileImports {
+	fi := r.imports[pkg]
+	if fi == nil {
+		fi = newFileImports(pkg.Types)
+		r.imports[pkg] = fi
+	}
+	return fi
+}
 
-	r.zeroDecls.Iterate(func(_ types.Type, val interface{}) {
-		if decl, _ := val.(*zeroDecl); decl != nil && decl.froms[r.pkgs[r.idx]] {
+func declsOf(decls map[*packages.Package]*typeutil.Map, pkg *packages.Package) *typeutil.Map {
+	m := decls[pkg]
+	if m == nil {
+		m = new(typeutil.Map)
+		decls[pkg] = m
+	}
+	return m
+}
+
+func (r *replacer) declsFile() *ast.File {
+	nilDecls := declsOf(r.nilDecls, r.pkgs[r.idx])
+	nilFuncs := declsOf(r.nilFuncs, r.pkgs[r.idx])
+	zeroDecls := declsOf(r.zeroDecls, r.pkgs[r.idx])
+	decls := make([]ast.Decl, 0, nilDecls.Len()+nilFuncs.Len()+zeroDecls.Len())
+
+	for _, nilDecls := range []*typeutil.Map{nilDecls, nilFuncs} {
+		nilDecls.Iterate(func(_ types.Type, val interface{}) {
+			if decl, _ := val.(*nilDecl); decl != nil {
+				decls = append(decls, decl.decl)
+			}
+		})
+	}
+
+	zeroDecls.Iterate(func(_ types.Type, val interface{}) {
+		if decl, _ := val.(*zeroDecl); decl != nil {
 			decls = append(decls, decl.funcdecl)
 		}
 	})
@@ -360,139 +953,208 @@ func (r *replacer) output(files []*ast.File) error {
 		return nil
 	}
 
-	dir := filepath.Join(r.dir, filepath.FromSlash(r.pkgs[r.idx].Types.Path()))
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return err
-	}
-
-	// copy go.mod
-	if err := r.copyGoMod(dir); err != nil {
-		return err
+	pkg := r.pkgs[r.idx]
+	// an external test package is shown with the package under test
+	pkgpath := pkg.Types.Path()
+	if isTestVariant(pkg) && strings.HasSuffix(pkg.Types.Name(), "_test") {
+		pkgpath = strings.TrimSuffix(pkgpath, "_test")
 	}
 
-	if err := r.outputDecls(dir); err != nil {
-		return err
+	// files which are not in GoFiles such as files generated by cgo cannot be overlaid
+	goFiles := make(map[string]bool, len(pkg.GoFiles))
+	for _, path := range pkg.GoFiles {
+		goFiles[path] = true
 	}
 
+	var dir string
 	for _, file := range files {
-		if err := r.outputFile(dir, file); err != nil {
-			return err
+		path := pkg.Fset.File(file.Pos()).Name()
+		if !goFiles[path] {
+			continue
 		}
-	}
+		dir = filepath.Dir(path)
+		r.result.names[path] = pkgpath + "/" + filepath.Base(path)
 
-	return nil
-}
-
-func (r *replacer) copyGoMod(dir string) error {
-	// without Go Modules
-	if r.pkgs[r.idx].Module == nil {
-		return nil
+		// non-test files of a test variant are output by the non-test variant
+		if isTestVariant(pkg) && !r.isTestFile(file) {
+			continue
+		}
+		if err := r.outputFile(path, file); err != nil {
+			return err
+		}
 	}
 
-	// sub package
-	if r.pkgs[r.idx].Module.Path != r.pkgs[r.idx].Types.Path() {
+	if dir == "" {
 		return nil
 	}
 
-	gomod, err := os.ReadFile(r.pkgs[r.idx].Module.GoMod)
-	if err != nil {
-		return err
-	}
-
-	if err := os.WriteFile(filepath.Join(dir, "go.mod"), gomod, 0o666); err != nil {
-		return err
-	}
+	return r.outputDecls(dir, pkgpath)
+}
 
-	return nil
+func (r *replacer) isTestFile(file *ast.File) bool {
+	return strings.HasSuffix(r.pkgs[r.idx].Fset.File(file.Pos()).Name(), "_test.go")
 }
 
-func (r *replacer) outputDecls(dir string) error {
+func (r *replacer) outputDecls(dir, pkgpath string) error {
 
 	var buf bytes.Buffer
 
 	fmt.Fprintln(&buf, "package", r.pkgs[r.idx].Syntax[0].Name.Name)
 
-	for _, file := range r.pkgs[r.idx].Syntax {
-		for _, impt := range file.Imports {
-			fmt.Fprint(&buf, "import ")
-			if impt.Name != nil {
-				fmt.Fprint(&buf, impt.Name.Name+" ")
-			}
-			fmt.Fprintln(&buf, impt.Path.Value)
-		}
+	// unused imports are deleted by goimports
+	fi := r.importsOf(r.pkgs[r.idx])
+	paths := make([]string, 0, len(fi.names))
+	for path := range fi.names {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "import %s %q\n", fi.names[path], path)
 	}
 
-	var count int
-	var err error
-	r.nilDecls.Iterate(func(_ types.Type, val interface{}) {
-		if decl, _ := val.(*nilDecl); decl != nil && decl.froms[r.pkgs[r.idx]] {
-			count++
-			err = multierr.Append(err, format.Node(&buf, token.NewFileSet(), decl.gendecl))
-			fmt.Fprintln(&buf)
-		}
-	})
-	if err != nil {
-		return err
+	key := dir + ":" + r.pkgs[r.idx].Types.Name()
+	declared := r.declared[key]
+	if declared == nil {
+		declared = make(map[string]bool)
+		r.declared[key] = declared
 	}
 
-	r.zeroDecls.Iterate(func(_ types.Type, val interface{}) {
-		if decl, _ := val.(*zeroDecl); decl != nil && decl.froms[r.pkgs[r.idx]] {
-			count++
-			err = multierr.Append(err, format.Node(&buf, token.NewFileSet(), decl.funcdecl))
-			fmt.Fprintln(&buf)
+	// declarations are output in the order of their names
+	decls := make(map[string]ast.Decl)
+	for _, nilDecls := range []map[*packages.Package]*typeutil.Map{r.nilDecls, r.nilFuncs} {
+		declsOf(nilDecls, r.pkgs[r.idx]).Iterate(func(_ types.Type, val interface{}) {
+			if decl, _ := val.(*nilDecl); decl != nil && !declared[decl.name] {
+				decls[decl.name] = decl.decl
+			}
+		})
+	}
+	declsOf(r.zeroDecls, r.pkgs[r.idx]).Iterate(func(_ types.Type, val interface{}) {
+		if decl, _ := val.(*zeroDecl); decl != nil && !declared[decl.name] {
+			decls[decl.name] = decl.funcdecl
 		}
 	})
+	if decl := r.anyDecls[r.pkgs[r.idx]]; decl != nil && !declared[decl.name] {
+		decls[decl.name] = decl.decl
+	}
 
 	// no decls
-	if count == 0 {
+	if len(decls) == 0 {
 		return nil
 	}
 
-	path := filepath.Join(dir, uniqName("nilless_decls_*.go", func(name string) bool {
-		_, err := os.Stat(filepath.Join(dir, name))
+	names := make([]string, 0, len(decls))
+	for name := range decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		declared[name] = true
+		if err := format.Node(&buf, token.NewFileSet(), decls[name]); err != nil {
+			return err
+		}
+		fmt.Fprintln(&buf)
+	}
+
+	// declarations for a test variant may refer types declared in test files
+	pattern := "nilless_decls_*.go"
+	if isTestVariant(r.pkgs[r.idx]) {
+		pattern = "nilless_decls_*_test.go"
+	}
+
+	path := filepath.Join(dir, uniqName(pattern, func(name string) bool {
+		path := filepath.Join(dir, name)
+		if _, ok := r.overlay[path]; ok {
+			return false
+		}
+		_, err := os.Stat(path)
 		return os.IsNotExist(err)
 	}))
 
-	src, err := imports.Process("", buf.Bytes(), nil)
+	src, err := imports.Process(path, buf.Bytes(), nil)
 	if err != nil {
 		return fmt.Errorf("goimports %s: %w", path, err)
 	}
 
-	// for debug
-	// fmt.Println(string(src))
-
-	if err := os.WriteFile(path, src, 0o666); err != nil {
-		return err
-	}
+	r.overlay[path] = src
+	r.result.names[path] = pkgpath + "/" + filepath.Base(path)
 
 	return nil
 }
 
-func (r *replacer) outputFile(dir string, file *ast.File) (rerr error) {
-	name := filepath.Join(dir, filepath.Base(r.pkgs[r.idx].Fset.File(file.Pos()).Name()))
-	f, err := os.Create(name)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		rerr = multierr.Append(rerr, f.Close())
-	}()
+func (r *replacer) outputFile(path string, file *ast.File) error {
+	r.deleteUnusedImports(file)
 
 	var buf bytes.Buffer
 	if err := format.Node(&buf, r.pkgs[r.idx].Fset, file); err != nil {
 		return err
 	}
 
-	var w io.Writer = f
-	// for debug
-	// w = io.MultiWriter(f, os.Stdout)
-	if _, err := io.Copy(w, &buf); err != nil {
-		return err
-	}
+	r.overlay[path] = buf.Bytes()
+	r.rewritten[path] = true
 
 	return nil
 }
 
+// deleteUnusedImports deletes imports which were used only in replaced types.
+// The declarations file imports them instead.
+func (r *replacer) deleteUnusedImports(file *ast.File) {
+	info := r.pkgs[r.idx].TypesInfo
+
+	used := make(map[types.Object]bool)
+	// packages which are used through dot imports
+	usedPkgs := make(map[*types.Package]bool)
+	sels := make(map[*ast.Ident]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			sels[n.Sel] = true
+		case *ast.Ident:
+			obj := info.Uses[n]
+			if pkgname, _ := obj.(*types.PkgName); pkgname != nil {
+				used[pkgname] = true
+			}
+			if obj != nil && obj.Pkg() != nil && !sels[n] && obj.Parent() == obj.Pkg().Scope() {
+				usedPkgs[obj.Pkg()] = true
+			}
+		}
+		return true
+	})
+
+	// DeleteNamedImport removes specs from file.Imports
+	specs := make([]*ast.ImportSpec, len(file.Imports))
+	copy(specs, file.Imports)
+	for _, spec := range specs {
+		var obj types.Object
+		switch {
+		case spec.Name == nil:
+			obj = info.Implicits[spec]
+		case spec.Name.Name == "_":
+			continue
+		case spec.Name.Name == ".":
+			if pkgname, _ := info.Defs[spec.Name].(*types.PkgName); pkgname != nil && !usedPkgs[pkgname.Imported()] {
+				obj = pkgname
+			}
+		default:
+			obj = info.Defs[spec.Name]
+		}
+
+		if obj == nil || used[obj] {
+			continue
+		}
+
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		var name string
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		astutil.DeleteNamedImport(r.pkgs[r.idx].Fset, file, name, path)
+	}
+}
+
 func (r *replacer) decl(c *astutil.Cursor, spec *ast.ValueSpec) error {
 	newSpec := &ast.ValueSpec{
 		Doc:     spec.Doc,
@@ -502,23 +1164,43 @@ func (r *replacer) decl(c *astutil.Cursor, spec *ast.ValueSpec) error {
 	}
 	copy(newSpec.Names, spec.Names)
 
+	// variables initialized by go:embed cannot have values
+	if hasEmbed(c, spec) {
+		return nil
+	}
+
 	for i, name := range spec.Names {
 		typ := r.pkgs[r.idx].TypesInfo.TypeOf(name)
+		// the type is kept if the value has the underlying type
+		if !types.Identical(nameableType(r.pkgs[r.idx].Types, typ), typ) {
+			newSpec.Type = spec.Type
+		}
 
+		// values are placed at the end of the names
+		// because a spec which has several names is broken into lines without positions
+		var val ast.Expr
+		var err error
 		switch {
-		case pointer.CanPoint(typ):
-			val, err := r.nilValue(typ)
-			if err != nil {
-				return err
+		// a type which cannot be written in the declarations file is written as in the source
+		case nameableType(r.pkgs[r.idx].Types, typ) == nil:
+			kind := RewriteVarDecl
+			if !canBeNil(typ) {
+				kind = RewriteZeroValue
 			}
-			newSpec.Values[i] = val
-		default:
-			val, err := r.zeroValue(typ)
-			if err != nil {
-				return err
+			val = r.anyValueAt(typ, nil, spec.Type, name.End(), kind)
+			// the declaration is kept if a value cannot be written
+			if val == nil {
+				return nil
 			}
-			newSpec.Values[i] = val
+		case canBeNil(typ):
+			val, err = r.nilValueAt(typ, name.End(), RewriteVarDecl)
+		default:
+			val, err = r.zeroValueAt(typ, name.End())
 		}
+		if err != nil {
+			return err
+		}
+		newSpec.Values[i] = val
 	}
 
 	c.Replace(newSpec)
@@ -526,13 +1208,135 @@ func (r *replacer) decl(c *astutil.Cursor, spec *ast.ValueSpec) error {
 	return nil
 }
 
-func (r *replacer) zeroValue(typ types.Type) (ast.Expr, error) {
-	decl, _ := r.zeroDecls.At(typ).(*zeroDecl)
+// hasEmbed reports whether spec has a go:embed directive.
+func hasEmbed(c *astutil.Cursor, spec *ast.ValueSpec) bool {
+	docs := []*ast.CommentGroup{spec.Doc}
+	if decl, _ := c.Parent().(*ast.GenDecl); decl != nil {
+		docs = append(docs, decl.Doc)
+	}
+
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		for _, comment := range doc.List {
+			if strings.HasPrefix(comment.Text, "//go:embed ") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// zeroValueAt returns a value of zeroValue which is placed at pos like nilValueAt.
+func (r *replacer) zeroValueAt(typ types.Type, pos token.Pos) (ast.Expr, error) {
+	decl, err := r.zeroValue(typ)
+	if err != nil || decl == nil {
+		return nil, err
+	}
+	r.addSite(decl.name, typ, pos, RewriteZeroValue)
+	val := decl.value()
+	placeAt(val, pos)
+	return val, nil
+}
+
+// anyValueAt returns a call of a generic function which returns the zero value of typ
+// and is placed at pos like nilValueAt.
+// It is used for types which cannot be written in the declarations file,
+// such as types declared in functions or unexported types of other packages.
+// The type argument is typExpr written in the source or inferred from expr,
+// which must have the type typ and no side effects.
+// It returns nil if the package cannot use generics.
+func (r *replacer) anyValueAt(typ types.Type, expr, typExpr ast.Expr, pos token.Pos, kind RewriteKind) ast.Expr {
+	if v := r.pkgs[r.idx].Types.GoVersion(); v != "" && version.Compare(v, "go1.18") < 0 {
+		return nil
+	}
+
+	decl := r.anyDecl()
+	call := &ast.CallExpr{Fun: ast.NewIdent(decl.name)}
+	switch {
+	case expr != nil:
+		call.Args = []ast.Expr{expr}
+	case typExpr != nil:
+		// the type expression is parsed again because its positions are moved to pos
+		typExpr, err := parser.ParseExpr(types.ExprString(typExpr))
+		if err != nil {
+			return nil
+		}
+		call.Fun = &ast.IndexExpr{X: call.Fun, Index: typExpr}
+	}
+
+	r.addSite(decl.name, typ, pos, kind)
+	placeAt(call, pos)
+	return call
+}
+
+// anyDecl returns the declaration of the generic function of anyValueAt in the current package.
+func (r *replacer) anyDecl() *nilDecl {
+	if decl := r.anyDecls[r.pkgs[r.idx]]; decl != nil {
+		return decl
+	}
+
+	name := r.declName("__nil", "any", types.Universe.Lookup("any").Type(), nil)
+	// any may be declared in the package
+	constraint, _ := parser.ParseExpr("interface{}")
+	decl := &nilDecl{
+		name: name,
+		decl: &ast.FuncDecl{
+			Name: ast.NewIdent(name),
+			Type: &ast.FuncType{
+				TypeParams: &ast.FieldList{List: []*ast.Field{{
+					Names: []*ast.Ident{ast.NewIdent("T")},
+					Type:  constraint,
+				}}},
+				Params: &ast.FieldList{List: []*ast.Field{{
+					Names: []*ast.Ident{ast.NewIdent("_")},
+					Type:  &ast.Ellipsis{Elt: ast.NewIdent("T")},
+				}}},
+				Results: &ast.FieldList{List: []*ast.Field{{
+					Names: []*ast.Ident{ast.NewIdent("_")},
+					Type:  ast.NewIdent("T"),
+				}}},
+			},
+			Body: &ast.BlockStmt{List: []ast.Stmt{new(ast.ReturnStmt)}},
+		},
+	}
+	r.anyDecls[r.pkgs[r.idx]] = decl
+
+	return decl
+}
+
+// isPure reports whether expr can be evaluated again without side effects.
+func isPure(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name != "_"
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isPure(expr.X)
+	case *ast.SelectorExpr:
+		return isPure(expr.X)
+	case *ast.StarExpr:
+		return isPure(expr.X)
+	case *ast.IndexExpr:
+		return isPure(expr.X) && isPure(expr.Index)
+	}
+	return false
+}
+
+// zeroValue returns the declaration of a zero value of typ or its underlying type like nilValue.
+func (r *replacer) zeroValue(typ types.Type) (*zeroDecl, error) {
+	typ = nameableType(r.pkgs[r.idx].Types, typ)
+	if typ == nil {
+		return nil, nil
+	}
+
+	zeroDecls := declsOf(r.zeroDecls, r.pkgs[r.idx])
+	decl, _ := zeroDecls.At(typ).(*zeroDecl)
 	if decl != nil {
-		decl.froms[r.pkgs[r.idx]] = true
-		return &ast.CallExpr{
-			Fun: ast.NewIdent(decl.name),
-		}, nil
+		return decl, nil
 	}
 
 	typExpr, err := parser.ParseExpr(r.typeString(typ))
@@ -540,49 +1344,94 @@ func (r *replacer) zeroValue(typ types.Type) (ast.Expr, error) {
 		return nil, fmt.Errorf("parse type string(%s): %w", typ.String(), err)
 	}
 
-	name := uniqName(fmt.Sprintf("__zero_%p_%d_*", r.pkgs[r.idx], r.hasher.Hash(typ)), func(name string) bool {
-		return r.pkgs[r.idx].Types.Scope().Lookup(name) == nil
-	})
-
+	tparams := typeParams(typ)
+	name := r.declName("__zero", "func", typ, tparams)
+	funcdecl, err := r.funcDecl(name, tparams, typExpr)
+	if err != nil {
+		return nil, err
+	}
 	decl = &zeroDecl{
-		froms: map[*packages.Package]bool{r.pkgs[r.idx]: true},
-		funcdecl: &ast.FuncDecl{
-			Name: ast.NewIdent(name),
-			Type: &ast.FuncType{
-				Params: &ast.FieldList{
-					List: []*ast.Field{},
-				},
-				Results: &ast.FieldList{
-					List: []*ast.Field{&ast.Field{
-						Names: []*ast.Ident{ast.NewIdent("_")},
-						Type:  typExpr,
-					}},
-				},
+		funcdecl: funcdecl,
+		name:     name,
+		tparams:  tparams,
+	}
+
+	zeroDecls.Set(typ, decl)
+
+	return decl, nil
+}
+
+// funcDecl returns a declaration of a function which returns the zero value of typExpr.
+// The function has the type parameters tparams.
+func (r *replacer) funcDecl(name string, tparams []*types.TypeParam, typExpr ast.Expr) (*ast.FuncDecl, error) {
+	funcdecl := &ast.FuncDecl{
+		Name: ast.NewIdent(name),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("_")},
+					Type:  typExpr,
+				}},
 			},
-			Body: &ast.BlockStmt{List: []ast.Stmt{new(ast.ReturnStmt)}},
 		},
-		name: name,
+		Body: &ast.BlockStmt{List: []ast.Stmt{new(ast.ReturnStmt)}},
 	}
 
-	r.zeroDecls.Set(typ, decl)
-	r.result.IsZero[name] = true
+	if len(tparams) == 0 {
+		return funcdecl, nil
+	}
 
-	return &ast.CallExpr{
-		Fun: ast.NewIdent(decl.name),
-	}, nil
+	funcdecl.Type.TypeParams = &ast.FieldList{}
+	for _, tparam := range tparams {
+		constraint, err := parser.ParseExpr(r.typeString(tparam.Constraint()))
+		if err != nil {
+			return nil, fmt.Errorf("parse constraint(%s): %w", tparam.Constraint().String(), err)
+		}
+		funcdecl.Type.TypeParams.List = append(funcdecl.Type.TypeParams.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(tparam.Obj().Name())},
+			Type:  constraint,
+		})
+	}
+
+	return funcdecl, nil
 }
 
 func (r *replacer) typeString(typ types.Type) string {
 	switch typ := typ.(type) {
 	case *types.Named:
 		obj := typ.Obj()
+		var name string
 		switch {
 		case obj.Parent() == types.Universe ||
 			obj.Parent() == r.pkgs[r.idx].Types.Scope():
-			return obj.Name()
+			name = obj.Name()
 		default:
-			return obj.Pkg().Name() + "." + obj.Name()
+			name = r.importsOf(r.pkgs[r.idx]).qualifier(obj.Pkg()) + "." + obj.Name()
+		}
+		targs := typ.TypeArgs()
+		if targs.Len() == 0 {
+			return name
+		}
+		args := make([]string, targs.Len())
+		for i := range args {
+			args[i] = r.typeString(targs.At(i))
 		}
+		return fmt.Sprintf("%s[%s]", name, strings.Join(args, ","))
+	case *types.TypeParam:
+		return typ.Obj().Name()
+	case *types.Union:
+		terms := make([]string, typ.Len())
+		for i := range terms {
+			term := typ.Term(i)
+			terms[i] = r.typeString(term.Type())
+			if term.Tilde() {
+				terms[i] = "~" + terms[i]
+			}
+		}
+		return strings.Join(terms, "|")
 	case *types.Pointer:
 		return "*" + r.typeString(typ.Elem())
 	case *types.Slice:
@@ -612,12 +1461,24 @@ func (r *replacer) typeString(typ types.Type) string {
 			}
 			methods = append(methods, m.Name()+r.signatureString(sig))
 		}
+		// methods of embedded interfaces are listed above
+		// but type sets of constraints such as ~int|~string and comparable must be embedded
+		for i := 0; i < typ.NumEmbeddeds(); i++ {
+			embedded := typ.EmbeddedType(i)
+			if iface, _ := embedded.Underlying().(*types.Interface); iface != nil && iface.IsMethodSet() {
+				continue
+			}
+			methods = append(methods, r.typeString(embedded))
+		}
 		return fmt.Sprintf("interface{ %s }", strings.Join(methods, ";"))
 	case *types.Struct:
 		fields := make([]string, typ.NumFields())
 		for i := range fields {
 			f := typ.Field(i)
 			fields[i] = fmt.Sprintf("%s %s", f.Name(), r.typeString(f.Type()))
+			if f.Embedded() {
+				fields[i] = r.typeString(f.Type())
+			}
 			if tag := typ.Tag(i); tag != "" {
 				fields[i] += " " + strconv.Quote(tag)
 			}
@@ -641,7 +1502,8 @@ func (r *replacer) signatureString(sig *types.Signature) string {
 	}
 
 	if sig.Variadic() {
-		args[len(args)-1] = "..." + args[len(args)-1]
+		last := sig.Params().At(len(args) - 1).Type().(*types.Slice)
+		args[len(args)-1] = "..." + r.typeString(last.Elem())
 	}
 
 	for i := range results {
@@ -1,11 +1,17 @@
 package nilless_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/ast"
+	"go/parser"
+	"go/token"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/gostaticanalysis/findnil/nilless"
@@ -23,10 +29,20 @@ func testdata(t *testing.T) string {
 }
 
 func TestLoad(t *testing.T) {
+	cases := []string{"a", "qualify", "imports"}
+	for _, dir := range cases {
+		dir := dir
+		t.Run(dir, func(t *testing.T) {
+			testLoad(t, dir)
+		})
+	}
+}
+
+func testLoad(t *testing.T, dir string) {
 	cfg := &packages.Config{
 		Mode: packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
 			packages.NeedTypes | packages.NeedDeps | packages.NeedModule,
-		Dir: filepath.Join(testdata(t), "a"),
+		Dir: filepath.Join(testdata(t), dir),
 	}
 	result, err := nilless.Load(cfg, "./...")
 	if err != nil {
@@ -35,14 +51,22 @@ func TestLoad(t *testing.T) {
 
 	var keys []string
 	expectNotes := make(map[string]*expect.Note)
+	// a line may have several replaced identifiers
+	replaced := make(map[string]bool)
 	for _, pkg := range result.Pkgs {
 		for _, file := range pkg.Syntax {
-			notes, err := expect.ExtractGo(pkg.Fset, file)
+			// notes are read from the original files
+			path := pkg.Fset.File(file.Pos()).Name()
+			if strings.HasPrefix(filepath.Base(path), "nilless_decls_") {
+				continue
+			}
+			fset := token.NewFileSet()
+			notes, err := expect.Parse(fset, path, nil)
 			if err != nil {
 				t.Fatal("unexpected error:", err)
 			}
 			for _, note := range notes {
-				pos := pkg.Fset.Position(note.Pos)
+				pos := fset.Position(note.Pos)
 				key := fmt.Sprintf("%s:%s:%d", pkg.Types.Path(), result.Base(pos.Filename), pos.Line)
 				keys = append(keys, key)
 				expectNotes[key] = note
@@ -60,29 +84,357 @@ func TestLoad(t *testing.T) {
 				return
 			}
 
-			pos := pkg.Fset.Position(id.Pos())
-			key := fmt.Sprintf("%s:%s:%d", pkg.Types.Path(), result.Base(pos.Filename), pos.Line)
-			note := expectNotes[key]
-
-			switch {
-			case result.IsNil[id.Name]:
-				if note == nil || (note.Name != "isNil" && note.Name != "isZero") {
-					t.Errorf("unexpected replacing nil (%s) in %v", id.Name, key)
-				}
-			case result.IsZero[id.Name]:
-				if note == nil || note.Name != "isZero" {
-					t.Errorf("unexpected replacing zero value (%s) in %v", id.Name, key)
-				}
+			sites := result.SitesOf(pkg.TypesInfo.Uses[id])
+			if len(sites) == 0 {
+				return
 			}
 
-			delete(expectNotes, key)
+			pos := result.Position(id.Pos())
+			key := fmt.Sprintf("%s:%s:%d", pkg.Types.Path(), result.Base(pos.Filename), pos.Line)
+			checkNote(t, expectNotes[key], sites[0], key)
+			replaced[key] = true
 		})
 	}
 
+	// sites have the positions in the original files
+	for _, site := range result.Sites {
+		key := fmt.Sprintf("%s:%s:%d", site.Object.Pkg().Path(), result.Base(site.Pos.Filename), site.Pos.Line)
+		checkNote(t, expectNotes[key], site, key)
+
+		// the deprecated fields are filled from the sites
+		if name := site.Object.Name(); !result.IsNil[name] && !result.IsZero[name] {
+			t.Errorf("%s of %s is in neither IsNil nor IsZero", name, key)
+		}
+	}
+
 	sort.Strings(keys)
 	for _, key := range keys {
-		if note := expectNotes[key]; note != nil {
+		if note := expectNotes[key]; !replaced[key] {
 			t.Errorf("expected replacing did not occur: %v", note)
 		}
 	}
 }
+
+func checkNote(t *testing.T, note *expect.Note, site *nilless.NilSite, key string) {
+	t.Helper()
+	switch {
+	case site.IsNil():
+		if note == nil || (note.Name != "isNil" && note.Name != "isZero") {
+			t.Errorf("unexpected replacing nil (%s) in %v", site.Object.Name(), key)
+		}
+	default:
+		if note == nil || note.Name != "isZero" {
+			t.Errorf("unexpected replacing zero value (%s) in %v", site.Object.Name(), key)
+		}
+	}
+}
+
+func TestResult_NilSite(t *testing.T) {
+	cfg := &packages.Config{
+		Mode: packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
+			packages.NeedTypes | packages.NeedDeps,
+		Dir: filepath.Join(testdata(t), "a"),
+	}
+	result, err := nilless.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var found bool
+	// columns holds the columns of the sites for each object and line
+	columns := make(map[string]map[int]bool)
+	for _, pkg := range result.Pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				id, _ := n.(*ast.Ident)
+				if id == nil {
+					return true
+				}
+				obj := pkg.TypesInfo.Uses[id]
+				sites := result.SitesOf(obj)
+				if len(sites) == 0 || !sites[0].IsNil() {
+					return true
+				}
+				found = true
+
+				pos := result.Position(id.Pos())
+				site := result.NilSite(obj, id.Pos())
+				if site == nil || site.Pos.Filename != pos.Filename || site.Pos.Line != pos.Line {
+					t.Errorf("NilSite(%s) at %v = %v", obj.Name(), pos, site)
+					return true
+				}
+
+				// nil values in the original source are matched by their columns,
+				// such as the two nil values of variadic(1, nil, nil)
+				switch site.Kind {
+				case nilless.RewriteVarDecl, nilless.RewriteNamedResult, nilless.RewriteOmittedField:
+				default:
+					if site.Pos.Column != pos.Column {
+						t.Errorf("NilSite(%s) at %v = %v, want the site at the column", obj.Name(), pos, site)
+					}
+					key := fmt.Sprintf("%s %s:%d", obj.Name(), site.Pos.Filename, site.Pos.Line)
+					if columns[key] == nil {
+						columns[key] = make(map[int]bool)
+					}
+					columns[key][site.Pos.Column] = true
+				}
+
+				// the package clause is never a nil value
+				if site := result.NilSite(obj, file.Package); site != nil {
+					t.Errorf("NilSite(%s) at the package clause = %v, want nil", obj.Name(), site)
+				}
+				return true
+			})
+		}
+	}
+
+	if !found {
+		t.Error("no nil values were replaced")
+	}
+
+	// each nil value of the same type in a line has its own site
+	var several bool
+	for _, cols := range columns {
+		several = several || len(cols) >= 2
+	}
+	if !several {
+		t.Error("no line has several nil values of the same type")
+	}
+}
+
+func TestLoad_deleteUnusedImports(t *testing.T) {
+	dir := filepath.Join(testdata(t), "imports")
+	cfg := &packages.Config{
+		Mode: packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
+			packages.NeedTypes | packages.NeedDeps,
+		Dir: dir,
+	}
+	result, err := nilless.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	src, ok := result.Overlay[filepath.Join(dir, "imports.go")]
+	if !ok {
+		t.Fatal("imports.go was not rewritten")
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "imports.go", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	got := make(map[string]bool)
+	for _, spec := range file.Imports {
+		got[spec.Path.Value] = true
+	}
+
+	// imports which are used only in the replaced types are deleted
+	for path, want := range map[string]bool{
+		`"bufio"`:   false,
+		`"bytes"`:   false,
+		`"strconv"`: true,
+		`"strings"`: true,
+	} {
+		if got[path] != want {
+			t.Errorf("import of %s: want %v, got %v", path, want, got[path])
+		}
+	}
+}
+
+func TestLoadInPlace(t *testing.T) {
+	dir := filepath.Join(testdata(t), "inplace")
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypesInfo | packages.NeedTypes | packages.NeedDeps,
+		Dir: dir,
+	}
+	result, err := nilless.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if len(result.Pkgs) != 1 {
+		t.Fatalf("the number of packages must be 1 but %d", len(result.Pkgs))
+	}
+
+	var replaced bool
+	pkg := result.Pkgs[0]
+	for _, file := range pkg.Syntax {
+		path := result.Fset.File(file.Pos()).Name()
+		if filepath.Dir(path) != dir {
+			t.Errorf("%s must be loaded in %s", path, dir)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if id, _ := n.(*ast.Ident); id != nil && len(result.SitesOf(pkg.TypesInfo.Uses[id])) != 0 {
+				replaced = true
+			}
+			return true
+		})
+	}
+
+	if !replaced {
+		t.Error("nil was not replaced")
+	}
+
+	if got, want := result.Base(filepath.Join(dir, "inplace.go")), "inplace/inplace.go"; got != want {
+		t.Errorf("Base() = %q, want %q", got, want)
+	}
+
+	// a variable initialized by go:embed cannot have a value
+	if src := result.Overlay[filepath.Join(dir, "inplace.go")]; !bytes.Contains(src, []byte("\nvar hello string\n")) {
+		t.Errorf("hello must not have a value:\n%s", src)
+	}
+
+	// the rewritten program must be compiled
+	overlay := struct{ Replace map[string]string }{make(map[string]string)}
+	tmp := t.TempDir()
+	for path, src := range result.Overlay {
+		file := filepath.Join(tmp, filepath.Base(path))
+		if err := os.WriteFile(file, src, 0o644); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		overlay.Replace[path] = file
+	}
+	overlayJSON, err := json.Marshal(overlay)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	overlayFile := filepath.Join(tmp, "overlay.json")
+	if err := os.WriteFile(overlayFile, overlayJSON, 0o644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	cmd := exec.Command("go", "build", "-overlay", overlayFile, "-o", os.DevNull, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go build: %v\n%s", err, out)
+	}
+}
+
+func TestResult_Position(t *testing.T) {
+	cfg := &packages.Config{
+		Mode: packages.NeedFiles | packages.NeedSyntax | packages.NeedTypesInfo |
+			packages.NeedTypes | packages.NeedDeps,
+		Dir: filepath.Join(testdata(t), "a"),
+	}
+	result, err := nilless.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	srcs := make(map[string][]byte)
+	for _, pkg := range result.Pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				// identifiers which are used may be added by nilless
+				id, _ := n.(*ast.Ident)
+				if _, ok := pkg.TypesInfo.Defs[id]; !ok || id.Name == "_" {
+					return true
+				}
+
+				pos := result.Position(id.Pos())
+				if !strings.HasPrefix(result.Base(pos.Filename), "a/") ||
+					strings.HasPrefix(filepath.Base(pos.Filename), "nilless_decls_") {
+					return true
+				}
+
+				src, ok := srcs[pos.Filename]
+				if !ok {
+					src, err = os.ReadFile(pos.Filename)
+					if err != nil {
+						t.Fatal("unexpected error:", err)
+					}
+					srcs[pos.Filename] = src
+				}
+
+				if pos.Offset > len(src) || !bytes.HasPrefix(src[pos.Offset:], []byte(id.Name)) {
+					t.Errorf("%s is mapped to %v", id.Name, pos)
+				}
+				return true
+			})
+		}
+	}
+
+	if len(srcs) == 0 {
+		t.Error("no positions were mapped")
+	}
+}
+
+func TestResult_Diff(t *testing.T) {
+	dir := filepath.Join(testdata(t), "inplace")
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypesInfo | packages.NeedTypes | packages.NeedDeps,
+		Dir: dir,
+	}
+	result, err := nilless.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.Diff(&buf, dir); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	diff := buf.String()
+
+	wants := []string{
+		"--- a/inplace.go\n+++ b/inplace.go\n@@ -10,6 +10,6 @@\n",
+		"\n-\tvar t *T\n+\tvar t = __nil_",
+		"--- /dev/null\n+++ b/nilless_decls_0.go\n@@ -0,0 +1,3 @@\n+package main\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff must contain %q:\n%s", want, diff)
+		}
+	}
+
+	// a variable initialized by go:embed cannot have a value
+	if strings.Contains(diff, "hello string") {
+		t.Errorf("hello must not be rewritten:\n%s", diff)
+	}
+}
+
+func TestOfflineEnv(t *testing.T) {
+	module := t.TempDir()
+	if err := os.WriteFile(filepath.Join(module, "go.mod"), []byte("module a\n"), 0o644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	sub := filepath.Join(module, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	vendored := t.TempDir()
+	if err := os.WriteFile(filepath.Join(vendored, "go.mod"), []byte("module a\n"), 0o644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Mkdir(filepath.Join(vendored, "vendor"), 0o755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	cases := []struct {
+		name string
+		env  []string
+		dir  string
+		want []string
+	}{
+		{"empty", []string{}, module, []string{"GOFLAGS=-mod=readonly", "GOPROXY=off"}},
+		{"keep", []string{"HOME=/home/a", "GOFLAGS=-tags=a"}, module, []string{"HOME=/home/a", "GOFLAGS=-tags=a", "GOFLAGS=-mod=readonly -tags=a", "GOPROXY=off"}},
+		{"subdir", []string{}, sub, []string{"GOFLAGS=-mod=readonly", "GOPROXY=off"}},
+		{"vendor_flag", []string{"GOFLAGS=-mod=vendor -v"}, module, []string{"GOFLAGS=-mod=vendor -v", "GOPROXY=off"}},
+		{"readonly_flag", []string{"GOFLAGS=-mod=readonly"}, module, []string{"GOFLAGS=-mod=readonly", "GOPROXY=off"}},
+		{"vendor_dir", []string{}, vendored, []string{"GOPROXY=off"}},
+		{"vendor_dir_and_flag", []string{"GOFLAGS=-mod=mod"}, vendored, []string{"GOFLAGS=-mod=mod", "GOPROXY=off"}},
+		{"no_module", []string{}, filepath.Dir(module), []string{"GOPROXY=off"}},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := nilless.OfflineEnv(tt.env, tt.dir)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("OfflineEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
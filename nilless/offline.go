@@ -0,0 +1,82 @@
+package nilless
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OfflineEnv returns env for packages.Config.Env which loads packages in dir without network access.
+// Modules are resolved only from the module cache and go.sum of the main module by GOPROXY=off.
+// A -mod flag in GOFLAGS is kept. Without it, -mod=readonly is added unless the main module has
+// a vendor directory, which the go command uses by default, so go.mod and go.sum are never updated.
+// -mod=readonly is used rather than -mod=mod, which may update go.mod and go.sum and
+// records requirements which are missing from them instead of reporting the errors.
+// If env is nil, the environment of the current process is used.
+// If dir is empty, the current directory is used.
+func OfflineEnv(env []string, dir string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+
+	// the last value of GOFLAGS takes effect
+	var goflags []string
+	for _, kv := range env {
+		if v, ok := cutPrefix(kv, "GOFLAGS="); ok {
+			goflags = strings.Fields(v)
+		}
+	}
+
+	newEnv := make([]string, 0, len(env)+2)
+	newEnv = append(newEnv, env...)
+
+	if !hasModFlag(goflags) {
+		if root := moduleRoot(dir); root != "" && !isDir(filepath.Join(root, "vendor")) {
+			flags := append([]string{"-mod=readonly"}, goflags...)
+			newEnv = append(newEnv, "GOFLAGS="+strings.Join(flags, " "))
+		}
+	}
+
+	return append(newEnv, "GOPROXY=off")
+}
+
+func hasModFlag(flags []string) bool {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "-mod=") || strings.HasPrefix(flag, "--mod=") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleRoot returns the nearest directory which has go.mod from dir.
+// It returns an empty string if there is no such directory.
+func moduleRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,194 @@
+package nilless
+
+import (
+	"go/scanner"
+	"go/token"
+	"sort"
+)
+
+// fileMap maps offsets in a rewritten file to offsets in the original file.
+// The tokens of the files are matched by their longest common subsequence.
+type fileMap struct {
+	orig   *token.File
+	tokens []mappedToken // tokens of the rewritten file
+}
+
+type mappedToken struct {
+	off, end int // offsets in the rewritten file
+	// offsets in the original file
+	// which are the region of the replaced tokens if the token is not matched
+	origOff, origEnd int
+	matched          bool
+}
+
+type scannedToken struct {
+	key      string
+	off, end int
+}
+
+func scanTokens(src []byte) []scannedToken {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+
+	var tokens []scannedToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		// automatically inserted semicolons depend on line breaks
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+
+		off := file.Offset(pos)
+		key, end := tok.String(), off+len(tok.String())
+		if lit != "" {
+			key, end = key+" "+lit, off+len(lit)
+		}
+		tokens = append(tokens, scannedToken{key: key, off: off, end: end})
+	}
+
+	return tokens
+}
+
+// newFileMap returns a fileMap from src to origSrc which is the source of the file at path.
+// The lines of the original file are computed from origSrc
+// because the token.File of the original file is modified by deleting imports.
+func newFileMap(path string, origSrc, src []byte) *fileMap {
+	orig := token.NewFileSet().AddFile(path, -1, len(origSrc))
+	orig.SetLinesForContent(origSrc)
+
+	origTokens, tokens := scanTokens(origSrc), scanTokens(src)
+	a := make([]string, len(origTokens))
+	for i := range origTokens {
+		a[i] = origTokens[i].key
+	}
+	b := make([]string, len(tokens))
+	for i := range tokens {
+		b[i] = tokens[i].key
+	}
+
+	m := &fileMap{
+		orig:   orig,
+		tokens: make([]mappedToken, len(tokens)),
+	}
+	// the original tokens which are replaced by tokens[i:j] are origTokens[prev+1:next]
+	prev := -1
+	var i int
+	for _, pair := range append(lcs(a, b), [2]int{len(a), len(b)}) {
+		next := pair[0]
+		origOff, origEnd := 0, 0
+		switch {
+		case prev+1 < next:
+			origOff, origEnd = origTokens[prev+1].off, origTokens[next-1].end
+		case prev >= 0:
+			origOff, origEnd = origTokens[prev].end, origTokens[prev].end
+		}
+		for ; i < pair[1]; i++ {
+			m.tokens[i] = mappedToken{
+				off:     tokens[i].off,
+				end:     tokens[i].end,
+				origOff: origOff,
+				origEnd: origEnd,
+			}
+		}
+
+		if next < len(a) {
+			m.tokens[i] = mappedToken{
+				off:     tokens[i].off,
+				end:     tokens[i].end,
+				origOff: origTokens[next].off,
+				origEnd: origTokens[next].end,
+				matched: true,
+			}
+			i++
+		}
+		prev = next
+	}
+
+	return m
+}
+
+// offset returns the offset in the original file of off in the rewritten file.
+func (m *fileMap) offset(off int) int {
+	i := sort.Search(len(m.tokens), func(i int) bool {
+		return m.tokens[i].off > off
+	}) - 1
+	if i < 0 {
+		return 0
+	}
+
+	t := m.tokens[i]
+	switch {
+	case off >= t.end:
+		return t.origEnd
+	case t.matched:
+		return t.origOff + off - t.off
+	default:
+		return t.origOff
+	}
+}
+
+// lcs returns the pairs of indexes of the elements of a and b
+// which are the longest common subsequence of them in ascending order.
+// It is computed by the algorithm of Myers, which is fast when a and b are similar.
+func lcs(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	// trace[d] holds v[-d:d+1] after the d-th step
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k] = x
+		}
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		if v[max+n-m] >= n && (n-m <= d && m-n <= d) && (d-(n-m))%2 == 0 {
+			break
+		}
+	}
+
+	var pairs [][2]int
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY && x > 0 && y > 0 && a[x-1] == b[y-1] {
+			pairs = append(pairs, [2]int{x - 1, y - 1})
+			x, y = x-1, y-1
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		pairs = append(pairs, [2]int{x - 1, y - 1})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+
+	return pairs
+}
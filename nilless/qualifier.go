@@ -0,0 +1,163 @@
+package nilless
+
+import (
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// fileImports holds the imports of a declarations file.
+// Each imported package has a name which is unique in the file,
+// so that packages which have the same name or are imported with other names
+// in the original files can be referred from the declarations file.
+type fileImports struct {
+	pkg   *types.Package
+	names map[string]string // path -> name
+	used  map[string]bool
+}
+
+func newFileImports(pkg *types.Package) *fileImports {
+	return &fileImports{
+		pkg:   pkg,
+		names: make(map[string]string),
+		used:  make(map[string]bool),
+	}
+}
+
+// qualifier is a types.Qualifier which adds an import of pkg if it has not been imported.
+func (fi *fileImports) qualifier(pkg *types.Package) string {
+	if pkg == nil || pkg.Path() == fi.pkg.Path() {
+		return ""
+	}
+
+	if name, ok := fi.names[pkg.Path()]; ok {
+		return name
+	}
+
+	name := pkg.Name()
+	for i := 2; fi.used[name] || fi.pkg.Scope().Lookup(name) != nil; i++ {
+		name = pkg.Name() + strconv.Itoa(i)
+	}
+	fi.names[pkg.Path()] = name
+	fi.used[name] = true
+
+	return name
+}
+
+// canName reports whether typ can be written in a declarations file of pkg.
+// Types declared in functions, unexported types of other packages and
+// types of packages which cannot be imported from pkg cannot be written.
+func canName(pkg *types.Package, typ types.Type) bool {
+	canNameTuple := func(tuple *types.Tuple) bool {
+		for i := 0; i < tuple.Len(); i++ {
+			if !canName(pkg, tuple.At(i).Type()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch typ := typ.(type) {
+	case *types.Named:
+		obj := typ.Obj()
+		switch {
+		case obj.Pkg() == nil: // error and comparable
+		case obj.Parent() != obj.Pkg().Scope():
+			return false
+		case obj.Pkg().Path() != pkg.Path() &&
+			(!obj.Exported() || !importable(pkg, obj.Pkg())):
+			return false
+		}
+		targs := typ.TypeArgs()
+		for i := 0; i < targs.Len(); i++ {
+			if !canName(pkg, targs.At(i)) {
+				return false
+			}
+		}
+		return true
+	case *types.Pointer:
+		return canName(pkg, typ.Elem())
+	case *types.Slice:
+		return canName(pkg, typ.Elem())
+	case *types.Array:
+		return canName(pkg, typ.Elem())
+	case *types.Map:
+		return canName(pkg, typ.Key()) && canName(pkg, typ.Elem())
+	case *types.Chan:
+		return canName(pkg, typ.Elem())
+	case *types.Signature:
+		return canNameTuple(typ.Params()) && canNameTuple(typ.Results())
+	case *types.Interface:
+		for i := 0; i < typ.NumMethods(); i++ {
+			m := typ.Method(i)
+			if !m.Exported() && m.Pkg().Path() != pkg.Path() {
+				return false
+			}
+			if !canName(pkg, m.Type()) {
+				return false
+			}
+		}
+		for i := 0; i < typ.NumEmbeddeds(); i++ {
+			if !canName(pkg, typ.EmbeddedType(i)) {
+				return false
+			}
+		}
+		return true
+	case *types.Struct:
+		for i := 0; i < typ.NumFields(); i++ {
+			f := typ.Field(i)
+			if !f.Exported() && f.Pkg().Path() != pkg.Path() {
+				return false
+			}
+			if !canName(pkg, f.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Union:
+		for i := 0; i < typ.Len(); i++ {
+			if !canName(pkg, typ.Term(i).Type()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return true
+}
+
+// importable reports whether to can be imported from pkg.
+// Main packages cannot be imported and internal packages can be imported
+// only from the packages rooted at the parent of the internal directory.
+func importable(pkg, to *types.Package) bool {
+	if to.Name() == "main" {
+		return false
+	}
+
+	// an external test package is in the same directory as the package under test
+	from := strings.TrimSuffix(pkg.Path(), "_test")
+	elems := strings.Split(to.Path(), "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] != "internal" {
+			continue
+		}
+		parent := strings.Join(elems[:i], "/")
+		return from == parent || strings.HasPrefix(from, parent+"/")
+	}
+
+	return true
+}
+
+// nameableType returns typ if it can be written in a declarations file of pkg.
+// Otherwise it returns the underlying type of typ if it can be written,
+// because a value of the underlying type is assignable to typ.
+// It returns nil if neither of them can be written.
+func nameableType(pkg *types.Package, typ types.Type) types.Type {
+	switch {
+	case canName(pkg, typ):
+		return typ
+	case canName(pkg, typ.Underlying()):
+		return typ.Underlying()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package nilless
+
+import (
+	"go/token"
+	"go/types"
+)
+
+// RewriteKind is a kind of the places where nil values or zero values are replaced.
+type RewriteKind string
+
+const (
+	// RewriteReturn is nil in a return statement.
+	RewriteReturn RewriteKind = "return"
+	// RewriteAssign is nil in an assignment statement.
+	RewriteAssign RewriteKind = "assign"
+	// RewriteVarInit is nil as an initial value of a variable declaration.
+	RewriteVarInit RewriteKind = "var_init"
+	// RewriteVarDecl is the nil value of a variable declared without values.
+	RewriteVarDecl RewriteKind = "var_decl"
+	// RewriteNamedResult is the nil value of a named result.
+	RewriteNamedResult RewriteKind = "named_result"
+	// RewriteConversion is nil converted into a type such as (*T)(nil).
+	RewriteConversion RewriteKind = "conversion"
+	// RewriteArgument is nil passed as an argument of a function call.
+	RewriteArgument RewriteKind = "argument"
+	// RewriteElement is nil as an element, a key or a field value of a composite literal.
+	RewriteElement RewriteKind = "element"
+	// RewriteOmittedField is the nil value of a field omitted in a struct literal.
+	RewriteOmittedField RewriteKind = "omitted_field"
+	// RewriteZeroValue is the zero value of a variable declared without values
+	// whose type cannot be nil.
+	RewriteZeroValue RewriteKind = "zero_value"
+)
+
+// NilSite is a nil value or a zero value in the original program
+// which is replaced with a variable or a function declared by nilless.
+// A value in a file which is shared by several packages such as a package and its test variant
+// has a site for each of them.
+type NilSite struct {
+	// Object is the variable or the function which is referred instead of the value
+	// in the rewritten program.
+	Object types.Object
+	// Type is the type of the value in the original program.
+	Type types.Type
+	// Pos is the position of the value in the original source.
+	Pos  token.Position
+	Kind RewriteKind
+}
+
+// IsNil reports whether the value of the site is nil.
+func (s *NilSite) IsNil() bool {
+	return s.Kind != RewriteZeroValue
+}
+
+// pendingSite is a site whose object is looked up after reloading.
+type pendingSite struct {
+	name string
+	site NilSite
+}
+
+// SitesOf returns the sites whose values are replaced with obj.
+func (r *Result) SitesOf(obj types.Object) []*NilSite {
+	return r.sites[obj]
+}
+
+// NilSite returns the site of the nil value which obj refers to at pos in the rewritten program.
+// pos is mapped to the original source and the site at the same file, line and column is returned.
+// Values which are inserted for variables declared without values, named results and omitted fields
+// have no tokens in the original source, so their positions are exact only in lines.
+// For them, the first site in the line is returned if no site has the column.
+// It returns nil if obj does not refer to a nil value at the position.
+func (r *Result) NilSite(obj types.Object, pos token.Pos) *NilSite {
+	position := r.Position(pos)
+	var inserted *NilSite
+	for _, site := range r.sites[obj] {
+		if !site.IsNil() || site.Pos.Filename != position.Filename || site.Pos.Line != position.Line {
+			continue
+		}
+		if site.Pos.Column == position.Column {
+			return site
+		}
+		if inserted == nil && site.inserted() {
+			inserted = site
+		}
+	}
+
+	return inserted
+}
+
+// inserted reports whether the value of the site is not written in the original source.
+func (s *NilSite) inserted() bool {
+	switch s.Kind {
+	case RewriteVarDecl, RewriteNamedResult, RewriteOmittedField, RewriteZeroValue:
+		return true
+	}
+	return false
+}
+
+// addSite records a site of a value which is replaced with the declaration named name.
+func (r *replacer) addSite(name string, typ types.Type, pos token.Pos, kind RewriteKind) {
+	position := r.pkgs[r.idx].Fset.Position(pos)
+	r.sites[position.Filename] = append(r.sites[position.Filename], &pendingSite{
+		name: name,
+		site: NilSite{Type: typ, Pos: position, Kind: kind},
+	})
+}
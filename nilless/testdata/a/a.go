@@ -33,3 +33,82 @@ func f(t *T) {
 func g() *T {
 	return nil //@ isNil
 }
+
+func h() {
+	t := new(T)
+	t = nil //@ isNil
+	var a, b *T //@ isNil
+	a, b = nil, nil //@ isNil
+	c := (*T)(nil) //@ isNil
+	f(nil) //@ isNil
+	println(t, a, b, c)
+	m := map[string]*T{}
+	m["a"] = nil //@ isNil
+	variadic(1, nil, nil) //@ isNil
+	variadic(1, nil...) //@ isNil
+	println(len(m))
+}
+
+func variadic(n int, ts ...*T) {}
+
+type S struct {
+	T      *T
+	Logger *zap.Logger
+	N      int
+	f      func()
+}
+
+func lits() {
+	_ = &S{}                    //@ isNil
+	_ = S{T: nil, N: 1}         //@ isNil
+	_ = S{new(T), nil, 1, nil}  //@ isNil
+	_ = []*T{nil, new(T)}       //@ isNil
+	_ = map[string]*T{"a": nil} //@ isNil
+	_ = []S{{T: new(T), Logger: zap.NewNop(), f: func() {}}}
+}
+
+func named() (t *T, err error) { //@ isNil
+	return
+}
+
+func namedAssigned(n int) (t *T, m int, _ error) { //@ isNil
+	if n > 0 {
+		t = new(T)
+	}
+	return
+}
+
+func namedNotNaked(n int) (t *T, err error) {
+	if n > 0 {
+		return new(T), nil //@ isNil
+	}
+	f := func() (u *T) { //@ isNil
+		return
+	}
+	return f(), err
+}
+
+type List[T any] struct {
+	Next *List[T]
+	V    T
+}
+
+type Number interface {
+	~int | ~float64
+}
+
+func generic[T any, N Number](l *List[T]) *List[T] {
+	var x *List[T] //@ isNil
+	var n N        //@ isZero
+	var m map[string]N //@ isNil
+	var p *List[int] //@ isNil
+	_ = &List[T]{} //@ isNil
+	println(x, n, m, p)
+	return nil //@ isNil
+}
+
+func (l *List[T]) Push(v T) (head *List[T]) { //@ isNil
+	return
+}
+
+var _ = generic[string, int]
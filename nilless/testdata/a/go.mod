@@ -1,9 +1,10 @@
 module a
 
-go 1.17
+go 1.18
+
+require go.uber.org/zap v1.19.1
 
 require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.19.1 // indirect
 )
@@ -0,0 +1,15 @@
+package imports
+
+import (
+	bb "bufio"
+	"bytes"
+	sc "strconv"
+	"strings"
+)
+
+func F() {
+	var b *bytes.Buffer   //@ isNil
+	var r *strings.Reader //@ isNil
+	var w *bb.Writer      //@ isNil
+	println(b, r, w, strings.ToUpper("a"), sc.Itoa(1))
+}
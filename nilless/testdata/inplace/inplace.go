@@ -0,0 +1,15 @@
+package main
+
+import _ "embed"
+
+//go:embed hello.txt
+var hello string
+
+type T struct {
+	N int
+}
+
+func main() {
+	var t *T
+	println(hello, t.N)
+}
@@ -0,0 +1,3 @@
+module qualify
+
+go 1.18
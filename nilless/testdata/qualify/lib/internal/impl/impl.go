@@ -0,0 +1,3 @@
+package impl
+
+type T struct{ N int }
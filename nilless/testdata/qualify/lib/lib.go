@@ -0,0 +1,17 @@
+package lib
+
+import "qualify/lib/internal/impl"
+
+type handler func()
+
+type t struct{ N int }
+
+func Handle(h handler) {}
+
+func Unexported(t *t) {}
+
+func Internal(t *impl.T) {}
+
+func Internals(ts []impl.T) {}
+
+func New() *t { return &t{} }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	. "qualify/dot"
+	"qualify/lib"
+	xrand "qualify/x/rand"
+	"qualify/y/rand"
+)
+
+func main() {
+	var xs *xrand.Source //@ isNil
+	var ys *rand.Source  //@ isNil
+	var d *Dot           //@ isNil
+	var e *struct {      //@ isNil
+		xrand.Source
+		N int
+	}
+	println(xs, ys, d, e)
+
+	lib.Handle(nil) //@ isNil
+	// nil of an unexported type is written with the type of the left hand side
+	u := lib.New()
+	u = nil //@ isNil
+	lib.Unexported(u)
+	lib.Unexported(nil)
+	lib.Internal(nil)
+	lib.Internals(nil)
+
+	type local struct{ N int }
+	var l *local //@ isNil
+	var z local  //@ isZero
+	type localFunc func()
+	f := localFunc(nil) //@ isNil
+	var g localFunc     //@ isNil
+	println(l, z.N, f, g)
+}
@@ -0,0 +1,102 @@
+package nilless
+
+import (
+	"go/ast"
+	"go/types"
+	"sort"
+
+	"github.com/gostaticanalysis/findnil/internal/pointer"
+)
+
+// canBeNil reports whether a value of typ can be nil.
+// Nil of a type parameter depends on its instantiation.
+func canBeNil(typ types.Type) bool {
+	if _, isTypeParam := typ.(*types.TypeParam); isTypeParam {
+		return false
+	}
+	return pointer.CanPoint(typ)
+}
+
+// typeParams returns the type parameters which typ refers to
+// directly or through constraints of them in the order of declarations.
+func typeParams(typ types.Type) []*types.TypeParam {
+	var tparams []*types.TypeParam
+	seen := make(map[*types.TypeParam]bool)
+
+	var walk func(typ types.Type)
+	walkTuple := func(tuple *types.Tuple) {
+		for i := 0; i < tuple.Len(); i++ {
+			walk(tuple.At(i).Type())
+		}
+	}
+	walk = func(typ types.Type) {
+		switch typ := typ.(type) {
+		case *types.TypeParam:
+			if seen[typ] {
+				return
+			}
+			seen[typ] = true
+			tparams = append(tparams, typ)
+			walk(typ.Constraint())
+		case *types.Named:
+			targs := typ.TypeArgs()
+			for i := 0; i < targs.Len(); i++ {
+				walk(targs.At(i))
+			}
+		case *types.Pointer:
+			walk(typ.Elem())
+		case *types.Slice:
+			walk(typ.Elem())
+		case *types.Array:
+			walk(typ.Elem())
+		case *types.Map:
+			walk(typ.Key())
+			walk(typ.Elem())
+		case *types.Chan:
+			walk(typ.Elem())
+		case *types.Signature:
+			walkTuple(typ.Params())
+			walkTuple(typ.Results())
+		case *types.Struct:
+			for i := 0; i < typ.NumFields(); i++ {
+				walk(typ.Field(i).Type())
+			}
+		case *types.Interface:
+			for i := 0; i < typ.NumMethods(); i++ {
+				walk(typ.Method(i).Type())
+			}
+			for i := 0; i < typ.NumEmbeddeds(); i++ {
+				walk(typ.EmbeddedType(i))
+			}
+		case *types.Union:
+			for i := 0; i < typ.Len(); i++ {
+				walk(typ.Term(i).Type())
+			}
+		}
+	}
+	walk(typ)
+
+	sort.Slice(tparams, func(i, j int) bool {
+		return tparams[i].Index() < tparams[j].Index()
+	})
+
+	return tparams
+}
+
+// instantiate returns an expression which instantiates the generic function name
+// with the type parameters in the scope of the replaced node.
+func instantiate(name string, tparams []*types.TypeParam) ast.Expr {
+	fun := ast.NewIdent(name)
+	switch len(tparams) {
+	case 0:
+		return fun
+	case 1:
+		return &ast.IndexExpr{X: fun, Index: ast.NewIdent(tparams[0].Obj().Name())}
+	}
+
+	indices := make([]ast.Expr, len(tparams))
+	for i, tparam := range tparams {
+		indices[i] = ast.NewIdent(tparam.Obj().Name())
+	}
+	return &ast.IndexListExpr{X: fun, Indices: indices}
+}
@@ -1,24 +1,30 @@
 package nilless
 
 import (
-	"math/rand"
+	"hash/fnv"
 	"strconv"
 	"strings"
-	"time"
 )
 
-var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
-
+// uniqName returns the first name which satisfies f.
+// The names are made by replacing the last "*" in pattern with 0, 1, 2 and so on.
 func uniqName(pattern string, f func(string) bool) string {
 	prefix, suffix := pattern, ""
 	if pos := strings.LastIndex(pattern, "*"); pos != -1 {
 		prefix, suffix = pattern[:pos], pattern[pos+1:]
 	}
 
-	for {
-		name := prefix + strconv.FormatUint(rnd.Uint64(), 10) + suffix
+	for i := 0; ; i++ {
+		name := prefix + strconv.Itoa(i) + suffix
 		if f(name) {
 			return name
 		}
 	}
 }
+
+// hashString returns the FNV-1a hash of s in hex.
+func hashString(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
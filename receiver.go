@@ -0,0 +1,136 @@
+package findnil
+
+import (
+	"go/token"
+	"go/types"
+
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"golang.org/x/tools/go/ssa"
+)
+
+// methodOf returns the method of sel if sel selects a method of a concrete type
+// through a pointer which is not embedded.
+func methodOf(sel *types.Selection) *types.Func {
+	if sel == nil || sel.Kind() != types.MethodVal || len(sel.Index()) != 1 {
+		return nil
+	}
+
+	if _, ok := sel.Recv().Underlying().(*types.Pointer); !ok {
+		return nil
+	}
+
+	method, _ := sel.Obj().(*types.Func)
+	return method
+}
+
+func hasPointerRecv(method *types.Func) bool {
+	recv := method.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return false
+	}
+	_, ok := recv.Type().Underlying().(*types.Pointer)
+	return ok
+}
+
+// isNilSafe reports whether the method fn can be called with a nil receiver.
+// A method is nil-safe when its receiver is never dereferenced unless it is checked against nil,
+// and it is passed only to nil-safe methods.
+//
+// visited holds the methods being checked, which are assumed to be nil-safe.
+// A method found nil-safe under the assumption may be unsafe when the assumed method is unsafe,
+// so it is cached only when the outermost method is also nil-safe.
+func (prog *Program) isNilSafe(fn *ssa.Function, visited map[*ssa.Function]bool) bool {
+	if fn == nil || fn.Signature.Recv() == nil || len(fn.Params) == 0 || len(fn.Blocks) == 0 {
+		return false
+	}
+
+	if safe, ok := prog.nilSafe[fn]; ok {
+		return safe
+	}
+	if visited[fn] {
+		return true
+	}
+	outermost := len(visited) == 0
+	visited[fn] = true
+
+	safe := prog.isNilSafeRecv(fn.Params[0], visited)
+	if prog.nilSafe == nil {
+		prog.nilSafe = make(map[*ssa.Function]bool)
+	}
+	switch {
+	case !safe:
+		prog.nilSafe[fn] = false
+	case outermost:
+		// all the methods which the outermost method depends on are nil-safe
+		for m := range visited {
+			prog.nilSafe[m] = true
+		}
+	}
+
+	return safe
+}
+
+func (prog *Program) isNilSafeRecv(recv *ssa.Parameter, visited map[*ssa.Function]bool) bool {
+	values := []ssa.Value{recv}
+	for _, ref := range refs(recv) {
+		// the receiver is stored into a local variable in the naive form
+		store, _ := ref.(*ssa.Store)
+		if store == nil || store.Val != recv {
+			continue
+		}
+		alloc, _ := store.Addr.(*ssa.Alloc)
+		if alloc == nil || !nilcheck.IsTrackable(alloc) {
+			return false
+		}
+		for _, load := range refs(alloc) {
+			if load, _ := load.(*ssa.UnOp); load != nil && load.Op == token.MUL {
+				values = append(values, load)
+			}
+		}
+	}
+
+	for _, v := range values {
+		if prog.isNonNil(v) {
+			continue
+		}
+
+		for _, ref := range refs(v) {
+			switch ref := ref.(type) {
+			case *ssa.DebugRef, *ssa.BinOp:
+			case *ssa.Store:
+				if ref.Val != recv {
+					return false
+				}
+			case ssa.CallInstruction:
+				common := ref.Common()
+				callee := common.StaticCallee()
+				if callee == nil || callee.Signature.Recv() == nil ||
+					len(common.Args) == 0 || common.Args[0] != v {
+					return false
+				}
+				if !prog.isNilSafe(callee, visited) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isNonNil reports whether v is a load of a local variable which is checked against nil
+// before the load.
+func (prog *Program) isNonNil(v ssa.Value) bool {
+	load, _ := v.(*ssa.UnOp)
+	if load == nil || load.Op != token.MUL {
+		return false
+	}
+
+	if stores, ok := prog.reachingStores(load); ok && len(stores) == 0 {
+		return true
+	}
+
+	return nilcheck.IsGuarded(load, load)
+}
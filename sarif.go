@@ -0,0 +1,180 @@
+package findnil
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// SARIF 2.1.0 log format.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string      `json:"version"`
+	Schema  string      `json:"$schema"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    *sarifTool     `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver *sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string       `json:"name"`
+	InformationURI string       `json:"informationUri"`
+	Rules          []*sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string        `json:"id"`
+	Name             string        `json:"name"`
+	ShortDescription *sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string           `json:"ruleId"`
+	RuleIndex int              `json:"ruleIndex"`
+	Level     string           `json:"level"`
+	Message   *sarifMessage    `json:"message"`
+	Locations []*sarifLocation `json:"locations"`
+	CodeFlows []*sarifCodeFlow `json:"codeFlows,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation *sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifCodeFlow struct {
+	ThreadFlows []*sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	Locations []*sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location *sarifLocation `json:"location"`
+}
+
+func sarifLevel(s Severity) string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// sarifRenderer renders diagnostics as a SARIF 2.1.0 log.
+type sarifRenderer struct{}
+
+func (sarifRenderer) Render(w io.Writer, diags []*Diagnostic) error {
+	kinds := make([]Kind, 0, len(rules))
+	for kind := range rules {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return rules[kinds[i]].id < rules[kinds[j]].id
+	})
+
+	driver := &sarifDriver{
+		Name:           "findnil",
+		InformationURI: "https://github.com/gostaticanalysis/findnil",
+	}
+	ruleIndex := make(map[Kind]int, len(kinds))
+	for i, kind := range kinds {
+		r := rules[kind]
+		ruleIndex[kind] = i
+		driver.Rules = append(driver.Rules, &sarifRule{
+			ID:               r.id,
+			Name:             r.name,
+			ShortDescription: &sarifMessage{Text: r.desc},
+		})
+	}
+
+	run := &sarifRun{
+		Tool:    &sarifTool{Driver: driver},
+		Results: make([]*sarifResult, 0, len(diags)),
+	}
+	for _, d := range diags {
+		result := &sarifResult{
+			RuleID:    d.Kind.RuleID(),
+			RuleIndex: ruleIndex[d.Kind],
+			Level:     sarifLevel(d.Severity),
+			Message:   &sarifMessage{Text: d.Message},
+			Locations: []*sarifLocation{{
+				PhysicalLocation: &sarifPhysicalLocation{
+					ArtifactLocation: &sarifArtifactLocation{URI: d.File},
+					Region: &sarifRegion{
+						StartLine:   d.Line,
+						StartColumn: d.Column,
+						EndLine:     d.EndLine,
+						EndColumn:   d.EndColumn,
+					},
+				},
+			}},
+		}
+
+		if len(d.Flow) != 0 {
+			tf := &sarifThreadFlow{}
+			for _, step := range d.Flow {
+				if step.File == "" {
+					continue
+				}
+				tf.Locations = append(tf.Locations, &sarifThreadFlowLocation{
+					Location: &sarifLocation{
+						PhysicalLocation: &sarifPhysicalLocation{
+							ArtifactLocation: &sarifArtifactLocation{URI: step.File},
+							Region: &sarifRegion{
+								StartLine:   step.Line,
+								StartColumn: step.Column,
+							},
+						},
+						Message: &sarifMessage{Text: step.Message},
+					},
+				})
+			}
+			result.CodeFlows = []*sarifCodeFlow{{ThreadFlows: []*sarifThreadFlow{tf}}}
+		}
+
+		run.Results = append(run.Results, result)
+	}
+
+	log := &sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []*sarifRun{run},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
@@ -0,0 +1,326 @@
+package findnil
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/gostaticanalysis/findnil/internal/pointer"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/ssa"
+)
+
+// site is an expression or a statement which panics or blocks forever when its operand is nil.
+type site struct {
+	kind Kind
+	pkg  *types.Package
+	// node is the reported expression.
+	node ast.Node
+	// value is the operand which may be nil.
+	value ssa.Value
+	// others are the operands which must also be nil for the finding,
+	// such as channels of other cases in a select statement.
+	others []ssa.Value
+	// iface is the conversion of value into an interface for KindTypedNil.
+	iface *ssa.MakeInterface
+	// valueRecv reports whether the selector calls a method which has a value receiver through a pointer.
+	valueRecv bool
+	// instance is the instantiation of a generic function which the site is analyzed in.
+	instance *ssa.Function
+}
+
+// collectSites returns the sites in pkg.
+//
+// Selectors are found from the syntax.
+// Other sites are found from SSA instructions by the positions of their expressions:
+// the left brackets of index and slice expressions, the left parentheses of calls,
+// the stars of pointer indirections, the arrows of sends and receives
+// and the keywords of range and select statements.
+// Selectors of fields are also found from SSA instructions of instantiations of generic functions
+// because the syntax is shared by their generic functions.
+func collectSites(prog *Program, pkg *ssa.Package) []*site {
+	info := prog.TypesInfo[pkg]
+	isArrayPtr := func(expr ast.Expr) bool {
+		ptr, _ := info.TypeOf(expr).Underlying().(*types.Pointer)
+		if ptr == nil {
+			return false
+		}
+		_, ok := ptr.Elem().Underlying().(*types.Array)
+		return ok
+	}
+
+	var sites []*site
+	indexes := make(map[token.Pos]*ast.IndexExpr)
+	slices := make(map[token.Pos]*ast.SliceExpr)
+	calls := make(map[token.Pos]*ast.CallExpr)
+	stars := make(map[token.Pos]*ast.StarExpr)
+	sends := make(map[token.Pos]*ast.SendStmt)
+	recvs := make(map[token.Pos]*ast.UnaryExpr)
+	ranges := make(map[token.Pos]*ast.RangeStmt)
+	selects := make(map[token.Pos]*ast.SelectStmt)
+	fields := make(map[token.Pos]*ast.SelectorExpr)
+	inspect := inspector.New(prog.Files[pkg])
+	filter := []ast.Node{
+		(*ast.SelectorExpr)(nil),
+		(*ast.IndexExpr)(nil),
+		(*ast.SliceExpr)(nil),
+		(*ast.CallExpr)(nil),
+		(*ast.StarExpr)(nil),
+		(*ast.SendStmt)(nil),
+		(*ast.UnaryExpr)(nil),
+		(*ast.RangeStmt)(nil),
+		(*ast.SelectStmt)(nil),
+	}
+	inspect.WithStack(filter, func(n ast.Node, push bool, stack []ast.Node) (proceed bool) {
+		if !push {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *ast.IndexExpr:
+			indexes[n.Lbrack] = n
+			return true
+		case *ast.SliceExpr:
+			if isArrayPtr(n.X) {
+				slices[n.Lbrack] = n
+			}
+			return true
+		case *ast.CallExpr:
+			calls[n.Lparen] = n
+			return true
+		case *ast.StarExpr:
+			// pointer types are also star expressions
+			if info.Types[n].IsValue() {
+				stars[n.Star] = n
+			}
+			return true
+		case *ast.SendStmt:
+			sends[n.Arrow] = n
+			return true
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				recvs[n.OpPos] = n
+			}
+			return true
+		case *ast.RangeStmt:
+			if _, ok := info.TypeOf(n.X).Underlying().(*types.Chan); ok {
+				ranges[n.For] = n
+			}
+			return true
+		case *ast.SelectStmt:
+			selects[n.Select] = n
+			return true
+		case *ast.SelectorExpr:
+			sel := n
+			typ := info.TypeOf(sel.X)
+			if !pointer.CanPoint(typ) {
+				return false
+			}
+
+			if s := info.Selections[sel]; s != nil && s.Kind() == types.FieldVal {
+				fields[sel.Sel.Pos()] = sel
+			}
+
+			// generic functions are analyzed for each instantiation
+			f := ssa.EnclosingFunction(pkg, stackToPath(stack))
+			if f == nil || isGeneric(outermost(f)) {
+				return false
+			}
+
+			v, _ := f.ValueForExpr(sel.X)
+			if v == nil {
+				return false
+			}
+
+			var valueRecv bool
+			if method := methodOf(info.Selections[sel]); method != nil {
+				switch {
+				case !hasPointerRecv(method):
+					// the pointer is dereferenced to copy the receiver
+					valueRecv = true
+				case prog.isNilSafe(prog.SSA.FuncValue(method), make(map[*ssa.Function]bool)):
+					return true
+				}
+			}
+
+			sites = append(sites, &site{
+				kind:      KindSelector,
+				pkg:       f.Package().Pkg,
+				node:      sel,
+				value:     v,
+				valueRecv: valueRecv,
+			})
+		}
+
+		return true
+	})
+
+	for _, fn := range prog.SrcFuncs[pkg] {
+		instance := instanceOf(fn)
+		add := func(kind Kind, node ast.Node, v ssa.Value) {
+			sites = append(sites, &site{
+				kind:     kind,
+				pkg:      pkg.Pkg,
+				node:     node,
+				value:    v,
+				instance: instance,
+			})
+		}
+
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				switch instr := instr.(type) {
+				case *ssa.FieldAddr:
+					if sel := fields[instr.Pos()]; sel != nil && instance != nil {
+						add(KindSelector, sel, instr.X)
+					}
+				case *ssa.MapUpdate:
+					if idx := indexes[instr.Pos()]; idx != nil {
+						add(KindMapAssign, idx, instr.Map)
+					}
+				case *ssa.IndexAddr:
+					if idx := indexes[instr.Pos()]; idx != nil && isArrayPtr(idx.X) {
+						add(KindDeref, idx, instr.X)
+					}
+				case *ssa.Slice:
+					if slice := slices[instr.Pos()]; slice != nil {
+						add(KindDeref, slice, instr.X)
+					}
+				case *ssa.UnOp:
+					switch instr.Op {
+					case token.MUL:
+						if star := stars[instr.Pos()]; star != nil {
+							add(KindDeref, star, instr.X)
+						}
+					case token.ARROW:
+						if recv := recvs[instr.Pos()]; recv != nil {
+							add(KindChanBlock, recv, instr.X)
+						} else if rng := ranges[instr.Pos()]; rng != nil {
+							add(KindChanBlock, rng, instr.X)
+						}
+					}
+				case *ssa.Send:
+					if send := sends[instr.Pos()]; send != nil {
+						add(KindChanBlock, send, instr.Chan)
+					}
+				case *ssa.Select:
+					// a select statement blocks forever only when all the channels are nil
+					sel := selects[instr.Pos()]
+					if sel == nil || !instr.Blocking || len(instr.States) == 0 {
+						continue
+					}
+					add(KindChanBlock, sel, instr.States[0].Chan)
+					for _, state := range instr.States[1:] {
+						sites[len(sites)-1].others = append(sites[len(sites)-1].others, state.Chan)
+					}
+				case *ssa.MakeInterface:
+					if expr := typedNilExpr(instr); expr != nil {
+						add(KindTypedNil, expr, instr.X)
+						sites[len(sites)-1].iface = instr
+					}
+				case *ssa.Store:
+					// *p = v
+					if star := stars[instr.Pos()]; star != nil {
+						add(KindDeref, star, instr.Addr)
+					}
+				case ssa.CallInstruction:
+					common := instr.Common()
+					if b, _ := common.Value.(*ssa.Builtin); b != nil && b.Name() == "close" {
+						if call := calls[common.Pos()]; call != nil {
+							add(KindChanClose, call, common.Args[0])
+						}
+						continue
+					}
+					if !isFuncValue(common.Value) {
+						continue
+					}
+					if call := calls[common.Pos()]; call != nil {
+						add(KindCall, call, common.Value)
+					}
+				}
+			}
+		}
+	}
+
+	return sites
+}
+
+// message returns the message of a finding at s and the message of the last step of the flow.
+func (s *site) message(prog *Program) (msg, step string) {
+	switch node := s.node.(type) {
+	case *ast.IndexExpr:
+		x := exprString(prog, node.X)
+		if s.kind == KindDeref {
+			return fmt.Sprintf("%s may be nil", x), fmt.Sprintf("%s is indexed", x)
+		}
+		msg = fmt.Sprintf("assignment to entry in nil map %s", x)
+		if obj := declaredObject(prog, s.pkg, node.X); obj != nil {
+			msg += fmt.Sprintf(" declared at %s", position(prog, obj.Pos()))
+		}
+		return msg, fmt.Sprintf("an entry of %s is assigned", x)
+	case *ast.SliceExpr:
+		x := exprString(prog, node.X)
+		return fmt.Sprintf("%s may be nil", x), fmt.Sprintf("%s is sliced", x)
+	case *ast.StarExpr:
+		x := exprString(prog, node.X)
+		return fmt.Sprintf("%s may be nil", x), fmt.Sprintf("%s is dereferenced", x)
+	case *ast.CallExpr:
+		if s.kind == KindChanClose {
+			ch := exprString(prog, node.Args[0])
+			return fmt.Sprintf("close of %s which may be nil panics", ch), fmt.Sprintf("%s is closed", ch)
+		}
+		fun := exprString(prog, node.Fun)
+		return fmt.Sprintf("call of %s which may be nil", fun), fmt.Sprintf("%s is called", fun)
+	case *ast.SendStmt:
+		ch := exprString(prog, node.Chan)
+		return fmt.Sprintf("send to %s which may be nil blocks forever", ch), fmt.Sprintf("a value is sent to %s", ch)
+	case *ast.UnaryExpr:
+		ch := exprString(prog, node.X)
+		return fmt.Sprintf("receive from %s which may be nil blocks forever", ch), fmt.Sprintf("a value is received from %s", ch)
+	case *ast.RangeStmt:
+		ch := exprString(prog, node.X)
+		return fmt.Sprintf("range over %s which may be nil blocks forever", ch), fmt.Sprintf("values are received from %s", ch)
+	case *ast.SelectStmt:
+		return "select blocks forever because all the channels may be nil", "the select statement waits for the channels"
+	}
+
+	if s.kind == KindTypedNil {
+		x := exprString(prog, s.node)
+		iface := types.TypeString(s.iface.Type(), types.RelativeTo(s.pkg))
+		return fmt.Sprintf("%s may be nil but %s holding it is not nil", x, iface), fmt.Sprintf("%s is converted to %s", x, iface)
+	}
+
+	if sel, _ := s.node.(*ast.SelectorExpr); sel != nil && s.valueRecv {
+		x := exprString(prog, sel.X)
+		return fmt.Sprintf("%s may be nil and method %s has a value receiver", x, sel.Sel.Name),
+			fmt.Sprintf("%s is dereferenced to call %s", x, sel.Sel.Name)
+	}
+
+	expr := exprString(prog, s.node)
+	return fmt.Sprintf("%s may be nil", expr), fmt.Sprintf("%s is dereferenced", expr)
+}
+
+// isFuncValue reports whether v is a function value of a dynamic call.
+// Calls of functions, methods, builtins and closures literally created are never calls of nil.
+func isFuncValue(v ssa.Value) bool {
+	switch v.(type) {
+	case *ssa.Function, *ssa.Builtin, *ssa.MakeClosure:
+		return false
+	}
+	_, ok := v.Type().Underlying().(*types.Signature)
+	return ok
+}
+
+// declaredObject returns the variable or the field which expr refers to.
+func declaredObject(prog *Program, pkg *types.Package, expr ast.Expr) types.Object {
+	info := prog.TypesInfo[prog.SSA.Package(pkg)]
+	switch expr := astutil.Unparen(expr).(type) {
+	case *ast.Ident:
+		return info.ObjectOf(expr)
+	case *ast.SelectorExpr:
+		return info.ObjectOf(expr.Sel)
+	}
+	return nil
+}
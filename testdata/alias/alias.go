@@ -0,0 +1,29 @@
+package main
+
+type T struct {
+	N int
+}
+
+var gt = new(T)
+
+func main() {
+	t := new(T)
+	reset(&t)
+	println(t.N) // NG: reset stores nil through the pointer
+
+	u := new(T)
+	keep(&u)
+	println(u.N) // OK: keep stores only non-nil values
+
+	p := &gt
+	*p = nil
+	println(gt.N) // NG: nil is stored through p
+}
+
+func reset(p **T) {
+	*p = nil
+}
+
+func keep(p **T) {
+	*p = new(T)
+}
@@ -0,0 +1,3 @@
+module alias
+
+go 1.18
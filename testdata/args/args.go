@@ -0,0 +1,42 @@
+package main
+
+type T struct {
+	N int
+}
+
+func main() {
+	f(nil)
+	f(new(T))
+	g(new(T))
+	h(new(T), nil)
+	outer(nil)
+	var m M
+	m.call(nil)
+}
+
+func f(t *T) {
+	println(t.N) // NG: nil is passed by main
+}
+
+func g(t *T) {
+	println(t.N) // OK: only non-nil values are passed
+}
+
+func h(a, b *T) {
+	println(a.N) // OK
+	println(b.N) // NG: nil is passed to the second parameter
+}
+
+func outer(t *T) {
+	inner(t)
+}
+
+func inner(t *T) {
+	println(t.N) // NG: nil is passed through outer
+}
+
+type M struct{}
+
+func (M) call(t *T) {
+	println(t.N) // NG: the receiver is not an argument
+}
@@ -0,0 +1,21 @@
+package main
+
+type T struct {
+	N int
+}
+
+func main() {
+	t := new(T)
+	println(t.N)
+	t = nil
+	println(t.N)
+
+	u := (*T)(nil)
+	println(u.N)
+
+	f(nil)
+}
+
+func f(t *T) {
+	println(t.N)
+}
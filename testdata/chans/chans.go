@@ -0,0 +1,36 @@
+package main
+
+func main() {
+	var ch chan int
+	close(ch)
+	ch <- 1
+	println(<-ch)
+	for v := range ch {
+		println(v)
+	}
+
+	ok := make(chan int, 1)
+	ok <- 1
+	println(<-ok)
+	close(ok)
+
+	var ch2 chan int
+	select {
+	case v := <-ch:
+		println(v)
+	case ch2 <- 1:
+	}
+
+	select {
+	case v := <-ch:
+		println(v)
+	case v := <-ok:
+		println(v)
+	}
+
+	select {
+	case v := <-ch:
+		println(v)
+	default:
+	}
+}
@@ -0,0 +1,3 @@
+module chans
+
+go 1.17
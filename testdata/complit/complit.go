@@ -0,0 +1,30 @@
+package main
+
+type Logger struct {
+	Prefix string
+}
+
+type Server struct {
+	Name   string
+	Logger *Logger
+}
+
+func main() {
+	s := &Server{Name: "a"}
+	println(s.Logger.Prefix)
+
+	t := Server{Name: "b", Logger: &Logger{}}
+	println(t.Logger.Prefix)
+
+	u := &Server{Logger: nil}
+	println(u.Name)
+}
+
+func newServer() *Server {
+	return &Server{Name: "c"}
+}
+
+func run() {
+	s := newServer()
+	println(s.Logger.Prefix)
+}
@@ -0,0 +1,3 @@
+module complit
+
+go 1.17
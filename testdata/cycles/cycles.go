@@ -0,0 +1,28 @@
+package main
+
+type T struct {
+	N int
+}
+
+func main() {
+	loop(3)
+	other()
+}
+
+func loop(n int) {
+	t := new(T)
+	for i := 0; i < n; i++ {
+		x := t
+		t = id(x)
+		println(t.N) // NG: id may return nil which other passes
+		println(x.N) // NG: x is t of the previous iteration
+	}
+}
+
+func id(t *T) *T {
+	return t
+}
+
+func other() {
+	println(id(nil) == nil)
+}
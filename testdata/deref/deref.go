@@ -0,0 +1,28 @@
+package main
+
+func main() {
+	var p *int
+	println(*p) // NG
+	*p = 1      // NG
+
+	q := new(int)
+	println(*q)
+
+	var a *[3]int
+	println(a[0]) // NG
+	_ = a[:]      // NG
+	println(len(a))
+
+	b := &[3]int{}
+	println(b[0])
+
+	var arr [3]int
+	println(arr[0])
+
+	var s *[]int
+	println(len(*s)) // NG
+
+	if p != nil {
+		println(*p)
+	}
+}
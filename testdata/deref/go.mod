@@ -0,0 +1,3 @@
+module deref
+
+go 1.17
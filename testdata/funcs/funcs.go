@@ -0,0 +1,32 @@
+package main
+
+type Handler struct {
+	OnStart func()
+	OnStop  func()
+}
+
+func main() {
+	var f func()
+	f() // NG
+
+	g := func() {}
+	g() // OK
+
+	h := &Handler{OnStop: func() {}}
+	h.OnStart() // NG: OnStart is never set
+	h.OnStop()  // OK
+
+	var cb func(int)
+	run(cb)
+	run(func(int) {})
+
+	if f != nil {
+		f() // OK
+	}
+
+	defer f() // NG
+}
+
+func run(callback func(int)) {
+	callback(1) // NG
+}
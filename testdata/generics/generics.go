@@ -0,0 +1,43 @@
+package main
+
+type List[T any] struct {
+	Next *List[T]
+	V    T
+}
+
+func Value[T any](l *List[T]) T {
+	return l.V
+}
+
+func Last[T any](l *List[T]) *List[T] {
+	var last *List[T]
+	for ; l != nil; l = l.Next {
+		last = l
+	}
+	return last
+}
+
+func Empty[T any]() T {
+	var l *List[T]
+	return l.V
+}
+
+func Find[K comparable, V any](m map[K]*V, k K) V {
+	p := m[k]
+	if p == nil {
+		var zero V
+		return zero
+	}
+	return *p
+}
+
+func main() {
+	println(Value(&List[int]{V: 1}))
+	println(Value[string](nil))
+
+	last := Last[int](nil)
+	println(last.V)
+
+	println(Empty[float64]())
+	println(Find(map[string]*int{}, "a"))
+}
@@ -0,0 +1,68 @@
+package main
+
+import "errors"
+
+type T struct {
+	N int
+}
+
+var gt *T
+
+func main() {
+	var t *T
+	if t != nil {
+		println(t.N) // guarded
+	}
+
+	if t == nil {
+		return
+	}
+	println(t.N) // guarded by the early return
+
+	v, err := f()
+	if err != nil {
+		return
+	}
+	println(v.N) // guarded by the error check
+
+	var u *T
+	if u == nil {
+		u = new(T)
+	}
+	println(u.N) // assigned when nil
+
+	if gt != nil {
+		println(gt.N) // guarded
+	}
+
+	w := new(T)
+	if w != nil {
+		w = g()
+		println(w.N) // NG: reassigned after the check
+	}
+
+	if gt != nil {
+		gt = g()
+		println(gt.N) // NG: reassigned after the check
+	}
+
+	if gt != nil {
+		reset()
+		println(gt.N) // NG: the call may reset it
+	}
+}
+
+func f() (*T, error) {
+	if len(errors.New("").Error()) == 0 {
+		return nil, errors.New("error")
+	}
+	return new(T), nil
+}
+
+func g() *T {
+	return nil
+}
+
+func reset() {
+	gt = nil
+}
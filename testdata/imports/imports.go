@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+type T struct {
+	N int
+}
+
+func main() {
+	// strings is used only in the type which is replaced, so its import is deleted
+	// and the lines of the file are merged
+	var r *strings.Reader
+	println(r.Len())
+
+	var t *T
+	println(t.N, len(os.Args))
+}
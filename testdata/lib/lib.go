@@ -0,0 +1,37 @@
+package lib
+
+type T struct {
+	N int
+}
+
+type Logger interface {
+	Log(msg string)
+}
+
+func F(t *T) int {
+	return t.N // NG: t may be nil unless -nilparams=none
+}
+
+func Log(l Logger, msg string) {
+	l.Log(msg)
+}
+
+func (t *T) Add(u *T) int {
+	return t.N + u.N // NG: u may be nil unless -nilparams=none but the receiver t is not
+}
+
+func Map(m map[string]*T) *T {
+	return m["a"]
+}
+
+func g(t *T) int {
+	return t.N // OK: g is not a root and G passes a non-nil pointer
+}
+
+func G() int {
+	return g(&T{N: 1})
+}
+
+type unexported struct{}
+
+func H(u *unexported) {}
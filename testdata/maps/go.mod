@@ -0,0 +1,3 @@
+module nilmaps
+
+go 1.17
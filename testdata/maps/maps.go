@@ -0,0 +1,29 @@
+package main
+
+var gm map[string]int
+
+func main() {
+	var m map[string]int
+	m["a"] = 1 // NG
+
+	m2 := make(map[string]int)
+	m2["a"] = 1 // OK
+
+	var m3 map[string]int
+	if m3 == nil {
+		m3 = make(map[string]int)
+	}
+	m3["a"] = 1 // OK
+
+	gm["a"] = 1 // NG
+
+	var nm map[string]int
+	inc(nm)
+	inc(map[string]int{})
+
+	println(m["a"]) // OK: reading a nil map does not panic
+}
+
+func inc(m map[string]int) {
+	m["a"]++ // NG
+}
@@ -0,0 +1,3 @@
+module naked
+
+go 1.17
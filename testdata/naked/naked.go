@@ -0,0 +1,32 @@
+package main
+
+type T struct {
+	N int
+}
+
+func main() {
+	t, err := find(0)
+	if err != nil {
+		return
+	}
+	println(t.N)
+
+	u, _ := lookup(1)
+	println(u.N)
+
+	v, _ := lookup(0)
+	if v != nil {
+		println(v.N)
+	}
+}
+
+func find(n int) (t *T, err error) {
+	return
+}
+
+func lookup(n int) (t *T, ok bool) {
+	if n > 0 {
+		t, ok = new(T), true
+	}
+	return
+}
@@ -0,0 +1,3 @@
+module recv
+
+go 1.17
@@ -0,0 +1,51 @@
+package main
+
+type T struct {
+	N int
+}
+
+func (t *T) Safe() int {
+	if t == nil {
+		return 0
+	}
+	return t.N
+}
+
+func (t *T) Unsafe() int {
+	return t.N
+}
+
+func (t T) Value() int {
+	return t.N
+}
+
+func (t *T) Delegate() int {
+	return t.Safe() + 1
+}
+
+func main() {
+	var t *T
+	println(t.Safe())
+	println(t.Unsafe())
+	println(t.Value())
+	println(t.Delegate())
+	recursive()
+}
+
+func recursive() {
+	var t *T
+	println(t.A(1))
+	println(t.B(1))
+}
+
+// A and B call each other and A dereferences the receiver.
+func (t *T) A(n int) int {
+	if n > 0 {
+		return t.B(n - 1)
+	}
+	return t.N
+}
+
+func (t *T) B(n int) int {
+	return t.A(n)
+}
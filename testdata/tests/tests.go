@@ -0,0 +1,13 @@
+package tests
+
+type T struct {
+	N int
+}
+
+func F(t *T) int {
+	return t.N // NG: TestF passes nil
+}
+
+func G(t *T) int {
+	return t.N // OK: TestG passes a non-nil pointer
+}
@@ -0,0 +1,18 @@
+package tests
+
+import "testing"
+
+func TestF(t *testing.T) {
+	var tt *T
+	if F(tt) != 0 {
+		t.Error("unexpected") // OK: the testing package passes a non-nil t
+	}
+}
+
+func TestG(t *testing.T) {
+	var tt *T
+	println(tt.N) // NG
+	if G(&T{N: 1}) != 1 {
+		t.Error("unexpected")
+	}
+}
@@ -0,0 +1,12 @@
+package tests_test
+
+import (
+	"testing"
+
+	"tests"
+)
+
+func TestX(t *testing.T) {
+	var tt *tests.T
+	println(tt.N) // NG
+}
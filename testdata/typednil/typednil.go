@@ -0,0 +1,79 @@
+package main
+
+type MyErr struct{}
+
+func (*MyErr) Error() string {
+	return "error"
+}
+
+func find(ok bool) error {
+	var p *MyErr
+	if !ok {
+		p = &MyErr{}
+	}
+	return p // NG
+}
+
+func check(ok bool) error {
+	var p *MyErr
+	if !ok {
+		p = &MyErr{}
+	}
+	if p == nil {
+		return nil
+	}
+	return p // OK
+}
+
+func load(ok bool) (int, error) {
+	var p *MyErr
+	return 0, p // NG
+}
+
+func ignored() error {
+	var p *MyErr
+	return p // OK: never compared with nil
+}
+
+func twice() error {
+	var p *MyErr
+	return p // NG: compared by both callers
+}
+
+func first() {
+	if err := twice(); err != nil {
+		println(err.Error())
+	}
+}
+
+func second() {
+	if err := twice(); err != nil {
+		println(err.Error())
+	}
+}
+
+func main() {
+	if err := find(true); err != nil {
+		println(err.Error())
+	}
+
+	if err := check(true); err != nil {
+		println(err.Error())
+	}
+
+	_, err := load(true)
+	if err == nil {
+		println("ok")
+	}
+
+	_ = ignored()
+
+	second()
+	first()
+
+	var e *MyErr
+	var err2 error = e // NG
+	if err2 != nil {
+		println(err2.Error())
+	}
+}
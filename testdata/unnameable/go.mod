@@ -0,0 +1,3 @@
+module unnameable
+
+go 1.18
@@ -0,0 +1,9 @@
+package lib
+
+type t struct {
+	N int
+}
+
+func New() *t {
+	return &t{}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "unnameable/lib"
+
+func main() {
+	// the type of p is unexported in lib
+	p := lib.New()
+	println(p.N) // OK
+	p = nil
+	println(p.N) // NG
+
+	type local struct {
+		N int
+	}
+	var l *local
+	println(l.N) // NG
+}
@@ -0,0 +1,119 @@
+package findnil
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/gostaticanalysis/findnil/internal/nilcheck"
+	"golang.org/x/tools/go/ssa"
+)
+
+// typedNilExpr returns the expression converted by mi
+// if mi converts a pointer into an interface.
+// A nil pointer in an interface makes the interface non-nil,
+// such as "return p" where p is a nil *MyErr and the result type is error.
+func typedNilExpr(mi *ssa.MakeInterface) ast.Expr {
+	if _, ok := mi.X.Type().Underlying().(*types.Pointer); !ok {
+		return nil
+	}
+
+	for _, ref := range refs(mi.X) {
+		if ref, _ := ref.(*ssa.DebugRef); ref != nil && ref.X == mi.X {
+			return ref.Expr
+		}
+	}
+
+	return nil
+}
+
+// comparedWithNil returns the position of a comparison of v with nil.
+// The value is followed through local variables, conversions and results of functions to their callers.
+// It returns token.NoPos if v is never compared with nil.
+func comparedWithNil(prog *Program, v ssa.Value, done map[ssa.Value]bool) token.Pos {
+	if done[v] {
+		return token.NoPos
+	}
+	done[v] = true
+
+	for _, ref := range refs(v) {
+		var pos token.Pos
+		switch ref := ref.(type) {
+		case *ssa.BinOp:
+			if op, x := nilcheck.Comparison(ref); x == v && op != token.ILLEGAL {
+				return ref.Pos()
+			}
+		case *ssa.Store:
+			if ref.Val != v {
+				continue
+			}
+			alloc, _ := ref.Addr.(*ssa.Alloc)
+			if alloc == nil {
+				continue
+			}
+			for _, load := range refs(alloc) {
+				if load, _ := load.(*ssa.UnOp); load != nil && load.Op == token.MUL {
+					if pos = comparedWithNil(prog, load, done); pos.IsValid() {
+						return pos
+					}
+				}
+			}
+		case *ssa.Phi:
+			pos = comparedWithNil(prog, ref, done)
+		case *ssa.ChangeInterface:
+			pos = comparedWithNil(prog, ref, done)
+		case *ssa.Return:
+			pos = comparedResultWithNil(prog, ref, v, done)
+		}
+
+		if pos.IsValid() {
+			return pos
+		}
+	}
+
+	return token.NoPos
+}
+
+// comparedResultWithNil returns the position of a comparison of the result v of ret with nil by callers.
+func comparedResultWithNil(prog *Program, ret *ssa.Return, v ssa.Value, done map[ssa.Value]bool) token.Pos {
+	if prog.CallGraph == nil {
+		return token.NoPos
+	}
+
+	node := prog.CallGraph.Nodes[ret.Parent()]
+	if node == nil {
+		return token.NoPos
+	}
+
+	for i, res := range ret.Results {
+		if res != v {
+			continue
+		}
+
+		for _, edge := range sortedEdges(prog, node.In) {
+			call, _ := edge.Site.(*ssa.Call)
+			if call == nil {
+				continue
+			}
+
+			if len(ret.Results) == 1 {
+				if pos := comparedWithNil(prog, call, done); pos.IsValid() {
+					return pos
+				}
+				continue
+			}
+
+			for _, ref := range refs(call) {
+				extract, _ := ref.(*ssa.Extract)
+				if extract == nil || extract.Index != i {
+					continue
+				}
+				if pos := comparedWithNil(prog, extract, done); pos.IsValid() {
+					return pos
+				}
+			}
+		}
+	}
+
+	return token.NoPos
+}